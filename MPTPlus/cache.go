@@ -0,0 +1,185 @@
+package MPTPlus
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+const (
+	// 每个节点除了编码数据之外额外占用的内存估算值
+	nodeCacheOverhead = 128
+)
+
+/*
+*所有DB共用一个已解码节点缓存,总容量由SetNodeCacheSize设置,key是DB的编号和节点hash
+*同一个hash不一定在每个DB中都存在,例如模拟器中每个节点的DB、校验用的DB副本和清理过的DB,所以key中需要区分DB
+*同一个DB中hash对应的节点永远不变,节点被清理时由DeleteNodes删除对应的缓存
+*GetNode的调用方会修改返回的节点,所以缓存中保存和返回的都是副本
+*
+*读取不加锁,只在entry上设置访问标记,写入和淘汰加锁,按照CLOCK算法淘汰最近没有访问过的节点
+ */
+type sharedNodeCache struct {
+	items    sync.Map // string -> *nodeCacheEntry
+	locker   sync.Mutex
+	ring     *list.List
+	hand     *list.Element
+	size     int64
+	capacity int64
+	hits     int64
+	misses   int64
+}
+
+type nodeCacheEntry struct {
+	key        string
+	element    *list.Element
+	node       *TrieNode
+	size       int64
+	referenced int32
+}
+
+var (
+	nodeCaches atomic.Value // *sharedNodeCache
+	dbIds      sync.Map     // db.IKVDatabase -> string
+	nextDbId   uint64
+)
+
+func init() {
+	nodeCaches.Store(newSharedNodeCache(db.DefaultCacheSize))
+}
+
+func newSharedNodeCache(capacity int64) *sharedNodeCache {
+	return &sharedNodeCache{ring: list.New(), capacity: capacity}
+}
+
+func (cache *sharedNodeCache) get(key string) (*TrieNode, bool) {
+	obj, exist := cache.items.Load(key)
+	if !exist {
+		atomic.AddInt64(&cache.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&cache.hits, 1)
+	entry := obj.(*nodeCacheEntry)
+	atomic.StoreInt32(&entry.referenced, 1)
+	return entry.node, true
+}
+
+func (cache *sharedNodeCache) set(key string, node *TrieNode, size int64) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	if size > cache.capacity {
+		return
+	}
+	if _, exist := cache.items.Load(key); exist {
+		return
+	}
+	entry := &nodeCacheEntry{key: key, node: node, size: size}
+	// 新节点插入到指针之前,转一圈之后才会被检查
+	if cache.hand == nil {
+		entry.element = cache.ring.PushBack(entry)
+	} else {
+		entry.element = cache.ring.InsertBefore(entry, cache.hand)
+	}
+	cache.items.Store(key, entry)
+	cache.size += size
+	for cache.size > cache.capacity {
+		cache.evict()
+	}
+}
+
+// 从指针开始跳过访问过的节点并清除标记,淘汰第一个没有访问过的节点
+func (cache *sharedNodeCache) evict() {
+	for {
+		if cache.hand == nil {
+			cache.hand = cache.ring.Front()
+		}
+		entry := cache.hand.Value.(*nodeCacheEntry)
+		if atomic.CompareAndSwapInt32(&entry.referenced, 1, 0) {
+			cache.hand = cache.hand.Next()
+			continue
+		}
+		cache.remove(entry.key)
+		return
+	}
+}
+
+func (cache *sharedNodeCache) delete(key string) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	cache.remove(key)
+}
+
+func (cache *sharedNodeCache) remove(key string) {
+	obj, exist := cache.items.Load(key)
+	if !exist {
+		return
+	}
+	entry := obj.(*nodeCacheEntry)
+	if cache.hand == entry.element {
+		cache.hand = entry.element.Next()
+	}
+	cache.ring.Remove(entry.element)
+	cache.items.Delete(key)
+	cache.size -= entry.size
+}
+
+// 一个DB在共用缓存中的视图,key加上DB的编号
+type dbNodeCache struct {
+	cache  *sharedNodeCache
+	prefix string
+}
+
+func nodeCache(database db.IKVDatabase) dbNodeCache {
+	prefix, exist := dbIds.Load(database)
+	if !exist {
+		id := make([]byte, 8)
+		binary.BigEndian.PutUint64(id, atomic.AddUint64(&nextDbId, 1))
+		prefix, _ = dbIds.LoadOrStore(database, string(id))
+	}
+	return dbNodeCache{cache: nodeCaches.Load().(*sharedNodeCache), prefix: prefix.(string)}
+}
+
+func (cache dbNodeCache) Get(hash []byte) (*TrieNode, bool) {
+	return cache.cache.get(cache.prefix + string(hash))
+}
+
+func (cache dbNodeCache) Set(hash []byte, node *TrieNode, size int64) {
+	cache.cache.set(cache.prefix+string(hash), node, size)
+}
+
+func (cache dbNodeCache) Delete(hash []byte) {
+	cache.cache.delete(cache.prefix + string(hash))
+}
+
+// 设置所有DB共用的节点缓存的总容量,单位byte,会清空已有的缓存
+func SetNodeCacheSize(size int64) {
+	if size <= 0 {
+		size = db.DefaultCacheSize
+	}
+	nodeCaches.Store(newSharedNodeCache(size))
+}
+
+func NodeCacheStats() db.CacheStats {
+	cache := nodeCaches.Load().(*sharedNodeCache)
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	return db.CacheStats{
+		Hits:     atomic.LoadInt64(&cache.hits),
+		Misses:   atomic.LoadInt64(&cache.misses),
+		Size:     cache.size,
+		Capacity: cache.capacity,
+		Count:    cache.ring.Len(),
+	}
+}
+
+func (node *TrieNode) clone() *TrieNode {
+	c := *node
+	if node.Sons != nil {
+		c.Sons = make(SortedSon, len(node.Sons))
+		copy(c.Sons, node.Sons)
+	}
+	return &c
+}
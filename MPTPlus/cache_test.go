@@ -0,0 +1,61 @@
+package MPTPlus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestNodeCachePerDB(t *testing.T) {
+	SetNodeCacheSize(0)
+	db1, db2 := db.NewMemKVDatabase(), db.NewMemKVDatabase()
+	trie := NewMTP(db1)
+	if err := trie.MustInsert([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := MTP_Tree(db1, trie.Root).GetValue([]byte("key")); err != nil || string(v) != "value" {
+		t.Fatalf("value=%s, err=%v", v, err)
+	}
+	if NodeCacheStats().Count == 0 {
+		t.Fatal("nodes read from db1 should be cached")
+	}
+	if _, err := MTP_Tree(db2, trie.Root).GetValue([]byte("key")); err == nil {
+		t.Fatal("nodes cached for db1 should not be served from db2")
+	}
+}
+
+// 所有DB共用一个容量,DB的数量不影响缓存占用的总内存
+func TestNodeCacheCapacity(t *testing.T) {
+	defer SetNodeCacheSize(0)
+	SetNodeCacheSize(4096)
+	for i := 0; i < 32; i++ {
+		database := db.NewMemKVDatabase()
+		trie := NewMTP(database)
+		for j := 0; j < 8; j++ {
+			trie.MustInsert([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("value%d", j)))
+		}
+		for j := 0; j < 8; j++ {
+			if v, err := MTP_Tree(database, trie.Root).GetValue([]byte(fmt.Sprintf("key%d", j))); err != nil || string(v) != fmt.Sprintf("value%d", j) {
+				t.Fatalf("value=%s, err=%v", v, err)
+			}
+		}
+	}
+	if stats := NodeCacheStats(); stats.Size > stats.Capacity || stats.Count == 0 {
+		t.Fatalf("cache should stay within its capacity, %+v", stats)
+	}
+
+	// 删除之后不能再从缓存中读取
+	database := db.NewMemKVDatabase()
+	trie := NewMTP(database)
+	trie.MustInsert([]byte("key"), []byte("value"))
+	if _, err := MTP_Tree(database, trie.Root).GetValue([]byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNodes(database, [][]byte{trie.Root}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MTP_Tree(database, trie.Root).GetValue([]byte("key")); err == nil {
+		t.Fatal("deleted node should not be served from the cache")
+	}
+}
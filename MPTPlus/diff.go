@@ -0,0 +1,159 @@
+package MPTPlus
+
+import (
+	"bytes"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/db"
+)
+
+const (
+	CHANGE_ADDED    = 1
+	CHANGE_REMOVED  = 2
+	CHANGE_MODIFIED = 3
+)
+
+// KeyChange描述了一个key在两个root之间的变化,新增时OldValue为空,删除时NewValue为空
+type KeyChange struct {
+	Key      types.HexBytes `json:"key"`
+	Type     int            `json:"type"`
+	OldValue types.HexBytes `json:"oldValue"`
+	NewValue types.HexBytes `json:"newValue"`
+}
+
+// diffEntry是遍历过程中待比较的一个子树或者一个value,path是从root到当前位置的完整路径
+type diffEntry struct {
+	path  []byte
+	hash  []byte
+	value bool
+}
+
+/**
+*比较rootA和rootB两棵树,按照key的字典序返回所有新增、删除和修改的key
+*
+*同时遍历两棵树,路径相同且hash相同的子树直接跳过;路径不同时展开路径较短或者字典序较小的子树继续比较
+ */
+func Diff(db db.IKVDatabase, rootA, rootB []byte) ([]KeyChange, error) {
+	changes := make([]KeyChange, 0)
+	if bytes.Equal(rootA, rootB) {
+		return changes, nil
+	}
+	mtp := &MTP{DB: db}
+	listA, err := mtp.expand(diffEntry{hash: rootA})
+	if err != nil {
+		return nil, err
+	}
+	listB, err := mtp.expand(diffEntry{hash: rootB})
+	if err != nil {
+		return nil, err
+	}
+
+	for len(listA) > 0 || len(listB) > 0 {
+		var a, b *diffEntry
+		if len(listA) > 0 {
+			a = &listA[0]
+		}
+		if len(listB) > 0 {
+			b = &listB[0]
+		}
+
+		switch {
+		case a != nil && b != nil && bytes.Equal(a.path, b.path):
+			if bytes.Equal(a.hash, b.hash) {
+				listA, listB = listA[1:], listB[1:]
+			} else if a.value && b.value {
+				change, err := mtp.change(a.path, CHANGE_MODIFIED, a.hash, b.hash)
+				if err != nil {
+					return nil, err
+				}
+				changes = append(changes, change)
+				listA, listB = listA[1:], listB[1:]
+			} else {
+				if !a.value {
+					if listA, err = mtp.expandFirst(listA); err != nil {
+						return nil, err
+					}
+				}
+				if !b.value {
+					if listB, err = mtp.expandFirst(listB); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case a != nil && b != nil && !a.value && bytes.HasPrefix(b.path, a.path):
+			if listA, err = mtp.expandFirst(listA); err != nil {
+				return nil, err
+			}
+		case a != nil && b != nil && !b.value && bytes.HasPrefix(a.path, b.path):
+			if listB, err = mtp.expandFirst(listB); err != nil {
+				return nil, err
+			}
+		case b == nil || (a != nil && bytes.Compare(a.path, b.path) < 0):
+			// a所在的子树在B中不存在
+			if !a.value {
+				listA, err = mtp.expandFirst(listA)
+			} else {
+				var change KeyChange
+				change, err = mtp.change(a.path, CHANGE_REMOVED, a.hash, nil)
+				changes, listA = append(changes, change), listA[1:]
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			// b所在的子树在A中不存在
+			if !b.value {
+				listB, err = mtp.expandFirst(listB)
+			} else {
+				var change KeyChange
+				change, err = mtp.change(b.path, CHANGE_ADDED, nil, b.hash)
+				changes, listB = append(changes, change), listB[1:]
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// 把子树展开成有序的儿子节点,叶子节点展开成value
+func (mtp *MTP) expand(entry diffEntry) ([]diffEntry, error) {
+	node, err := mtp.GetNode(entry.hash)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, KeyNotExistError
+	}
+	if node.Leaf {
+		return []diffEntry{{path: entry.path, hash: node.Sons[0].Hash, value: true}}, nil
+	}
+	entries := make([]diffEntry, 0, len(node.Sons))
+	for _, son := range node.Sons {
+		path := append(append(make([]byte, 0, len(entry.path)+len(son.PathValue)), entry.path...), son.PathValue...)
+		entries = append(entries, diffEntry{path: path, hash: son.Hash})
+	}
+	return entries, nil
+}
+
+func (mtp *MTP) expandFirst(list []diffEntry) ([]diffEntry, error) {
+	entries, err := mtp.expand(list[0])
+	if err != nil {
+		return nil, err
+	}
+	return append(entries, list[1:]...), nil
+}
+
+func (mtp *MTP) change(key []byte, changeType int, oldHash, newHash []byte) (change KeyChange, err error) {
+	change = KeyChange{Key: key, Type: changeType}
+	if oldHash != nil {
+		if change.OldValue, err = mtp.getValue(oldHash); err != nil {
+			return
+		}
+	}
+	if newHash != nil {
+		change.NewValue, err = mtp.getValue(newHash)
+	}
+	return
+}
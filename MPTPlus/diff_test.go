@@ -0,0 +1,52 @@
+package MPTPlus
+
+import (
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestDiff(t *testing.T) {
+	memDB := db.NewMemKVDatabase()
+	trie := NewMTP(memDB)
+	for _, key := range []string{"HZhouWorld1", "HZhouWorld2", "HZhouxun", "HelloWorld1", "HelloX", "zhouxun"} {
+		trie.MustInsert([]byte(key), []byte("value of "+key))
+	}
+	rootA := trie.Root
+
+	trie.MustInsert([]byte("HZhouWorld2"), []byte("new value"))
+	trie.MustInsert([]byte("HelloWorld2"), []byte("value of HelloWorld2"))
+	trie.MustInsert([]byte("x"), []byte("value of x"))
+	trie.Delete([]byte("HZhouxun"))
+	trie.Delete([]byte("zhouxun"))
+	rootB := trie.Root
+
+	changes, err := Diff(memDB, rootA, rootB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		key        string
+		changeType int
+		old, new   string
+	}{
+		{"HZhouWorld2", CHANGE_MODIFIED, "value of HZhouWorld2", "new value"},
+		{"HZhouxun", CHANGE_REMOVED, "value of HZhouxun", ""},
+		{"HelloWorld2", CHANGE_ADDED, "", "value of HelloWorld2"},
+		{"x", CHANGE_ADDED, "", "value of x"},
+		{"zhouxun", CHANGE_REMOVED, "value of zhouxun", ""},
+	}
+	if len(changes) != len(expect) {
+		t.Fatalf("got %d changes, expect %d, %v", len(changes), len(expect), changes)
+	}
+	for i, e := range expect {
+		c := changes[i]
+		if string(c.Key) != e.key || c.Type != e.changeType || string(c.OldValue) != e.old || string(c.NewValue) != e.new {
+			t.Fatalf("change %d mismatch, got key=%s type=%d old=%s new=%s", i, c.Key, c.Type, c.OldValue, c.NewValue)
+		}
+	}
+
+	if changes, err := Diff(memDB, rootB, rootB); err != nil || len(changes) != 0 {
+		t.Fatalf("diff of same root should be empty, got %v, err=%v", changes, err)
+	}
+}
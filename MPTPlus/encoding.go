@@ -0,0 +1,46 @@
+package MPTPlus
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+/*
+*节点的编码方式,节点hash是编码之后数据的hash,所以同一棵树的编码方式必须是确定的
+*NODE_ENCODING_JSON是最早的编码方式,数据以'{'开头
+*NODE_ENCODING_RLP使用一个字节的版本号作为前缀,后面是TrieNode的RLP编码
+*解码时根据第一个字节判断编码方式,所以新旧编码的节点可以在同一棵树中共存
+ */
+const (
+	NODE_ENCODING_JSON = 0
+	NODE_ENCODING_RLP  = 1
+)
+
+var InvalidEncodingError = errors.New("invalid node encoding")
+
+func encodeNode(node TrieNode, encoding int) ([]byte, error) {
+	switch encoding {
+	case NODE_ENCODING_JSON:
+		return json.Marshal(node)
+	case NODE_ENCODING_RLP:
+		data, err := rlp.EncodeToBytes(node)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{NODE_ENCODING_RLP}, data...), nil
+	default:
+		return nil, InvalidEncodingError
+	}
+}
+
+func decodeNode(data []byte) (*TrieNode, error) {
+	var node TrieNode
+	if len(data) > 0 && data[0] == NODE_ENCODING_RLP {
+		err := rlp.DecodeBytes(data[1:], &node)
+		return &node, err
+	}
+	err := json.Unmarshal(data, &node)
+	return &node, err
+}
@@ -0,0 +1,49 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestMTPRLPEncoding(t *testing.T) {
+	keys := []string{"HZhouWorld1", "HZhouWorld2", "HZhouxun", "HelloWorld1", "HelloX", "x", "zhouxun"}
+	memDB := db.NewMemKVDatabase()
+	jsonTrie, rlpTrie := NewMTP(memDB), NewMTP(memDB)
+	rlpTrie.Encoding = NODE_ENCODING_RLP
+	for _, key := range keys[:4] {
+		jsonTrie.MustInsert([]byte(key), []byte("value of "+key))
+		rlpTrie.MustInsert([]byte(key), []byte("value of "+key))
+	}
+	jsonRoot, _ := memDB.Get(jsonTrie.Root)
+	rlpRoot, _ := memDB.Get(rlpTrie.Root)
+	if bytes.Equal(jsonTrie.Root, rlpTrie.Root) || len(rlpRoot) >= len(jsonRoot) {
+		t.Fatalf("rlp root node should differ and be smaller, json=%d bytes, rlp=%d bytes", len(jsonRoot), len(rlpRoot))
+	}
+
+	// 旧的JSON节点在切换编码之后仍然可以读取和更新
+	mixed := MTP_Tree(memDB, jsonTrie.Root)
+	mixed.Encoding = NODE_ENCODING_RLP
+	for _, key := range keys[4:] {
+		mixed.MustInsert([]byte(key), []byte("value of "+key))
+		rlpTrie.MustInsert([]byte(key), []byte("value of "+key))
+	}
+	mixed.MustInsert([]byte(keys[0]), []byte("new value"))
+	for i, key := range keys {
+		expect := "value of " + key
+		if i == 0 {
+			expect = "new value"
+		}
+		if v, err := mixed.GetValue([]byte(key)); err != nil || string(v) != expect {
+			t.Fatalf("read %s from mixed trie failed, value=%s, err=%v", key, v, err)
+		}
+		proof, err := rlpTrie.Prove([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyProof(rlpTrie.Root, []byte(key), *proof); err != nil {
+			t.Fatalf("verify rlp proof of %s failed, %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package MPTPlus
+
+import (
+	"bytes"
+)
+
+/**
+*按照key的字典序遍历[start, end)范围内的所有key和value,start和end为nil表示不限制
+*
+*SortedSon保证同一个节点的儿子节点是有序的,所以深度优先遍历的顺序就是key的字典序
+*fn返回false时停止遍历,fn中不能修改当前树
+ */
+func (mtp *MTP) Iterate(start, end []byte, fn func(key, value []byte) bool) error {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
+	_, err := mtp.iterate(mtp.Root, nil, start, end, fn)
+	return err
+}
+
+// 遍历所有以prefix开头的key
+func (mtp *MTP) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	return mtp.Iterate(prefix, prefixEnd(prefix), fn)
+}
+
+func (mtp *MTP) iterate(hash, path, start, end []byte, fn func(key, value []byte) bool) (bool, error) {
+	node, err := mtp.GetNode(hash)
+	if err != nil {
+		return false, err
+	}
+	if node == nil {
+		return false, KeyNotExistError
+	}
+	if node.Leaf {
+		if (start != nil && bytes.Compare(path, start) < 0) || (end != nil && bytes.Compare(path, end) >= 0) {
+			return true, nil
+		}
+		value, err := mtp.getValue(node.Sons[0].Hash)
+		if err != nil {
+			return false, err
+		}
+		return fn(path, value), nil
+	}
+	for _, son := range node.Sons {
+		sonPath := append(append(make([]byte, 0, len(path)+len(son.PathValue)), path...), son.PathValue...)
+		// 子树中所有的key都以sonPath开头
+		if end != nil && bytes.Compare(sonPath, end) >= 0 {
+			return true, nil
+		}
+		if start != nil && bytes.Compare(sonPath, start) < 0 && !bytes.HasPrefix(start, sonPath) {
+			continue
+		}
+		if goon, err := mtp.iterate(son.Hash, sonPath, start, end, fn); err != nil || !goon {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 返回大于所有以prefix开头的key的最小值,prefix全为0xff时返回nil
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+/**
+*深度优先遍历树中的所有节点hash,包括叶子节点指向的value的hash(isValue为true)
+*
+*fn在读取节点之前调用,返回false时跳过当前节点的子树,用于统计或者清理DB中的节点
+ */
+func (mtp *MTP) WalkNodes(fn func(hash []byte, isValue bool) bool) error {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
+	return mtp.walkNodes(mtp.Root, false, fn)
+}
+
+func (mtp *MTP) walkNodes(hash []byte, isValue bool, fn func(hash []byte, isValue bool) bool) error {
+	if !fn(hash, isValue) || isValue {
+		return nil
+	}
+	node, err := mtp.GetNode(hash)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return KeyNotExistError
+	}
+	for _, son := range node.Sons {
+		if err := mtp.walkNodes(son.Hash, node.Leaf, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package MPTPlus
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestMTPIterate(t *testing.T) {
+	keys := []string{"zhouxun", "HZhouWorld1", "x", "HelloWorld2", "HZhouxun", "HelloX", "HelloWorld1", "HZhouWorld2"}
+	trie := NewMTP(db.NewMemKVDatabase())
+	for _, key := range keys {
+		if err := trie.MustInsert([]byte(key), []byte("value of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	collect := func(start, end []byte) []string {
+		result := make([]string, 0)
+		err := trie.Iterate(start, end, func(key, value []byte) bool {
+			if string(value) != "value of "+string(key) {
+				t.Fatalf("value mismatch for %s", key)
+			}
+			result = append(result, string(key))
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if all := collect(nil, nil); strings.Join(all, ",") != strings.Join(sorted, ",") {
+		t.Fatalf("iterate all got %v, expect %v", all, sorted)
+	}
+	if r := collect([]byte("HZhouxun"), []byte("HelloX")); strings.Join(r, ",") != "HZhouxun,HelloWorld1,HelloWorld2" {
+		t.Fatalf("iterate range got %v", r)
+	}
+	if r := collect([]byte("HZ"), []byte("HZ\xff")); len(r) != 3 {
+		t.Fatalf("iterate HZ got %v", r)
+	}
+
+	prefixed := make([]string, 0)
+	trie.IteratePrefix([]byte("Hello"), func(key, value []byte) bool {
+		prefixed = append(prefixed, string(key))
+		return len(prefixed) < 2
+	})
+	if strings.Join(prefixed, ",") != "HelloWorld1,HelloWorld2" {
+		t.Fatalf("iterate prefix got %v", prefixed)
+	}
+}
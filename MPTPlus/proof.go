@@ -0,0 +1,170 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+var (
+	KeyNotExistError  = errors.New("key not exist")
+	KeyExistError     = errors.New("key exist")
+	InvalidProofError = errors.New("invalid proof")
+)
+
+/*
+*Proof是key在某个root下存在或者不存在的证明
+*Nodes是从root节点沿key的前缀路径向下每个节点在数据库中存储的原始数据
+*存在性证明的路径到叶子节点为止,Value是key对应的值;不存在性证明的路径到分叉节点为止,Value为空
+*校验时只需要root的hash即可,不需要访问数据库
+ */
+type Proof struct {
+	Nodes []types.HexBytes `json:"nodes"`
+	Value types.HexBytes   `json:"value"`
+}
+
+func (proof Proof) Bytes() []byte {
+	data, _ := json.Marshal(proof)
+	return data
+}
+
+// 生成key的存在性证明,key不存在时返回KeyNotExistError
+func (mtp *MTP) Prove(key []byte) (*Proof, error) {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
+	nodes, leaf, err := mtp.proofNodes(key)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil {
+		return nil, KeyNotExistError
+	}
+	value, err := mtp.getValue(leaf.Sons[0].Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{Nodes: nodes, Value: value}, nil
+}
+
+// 生成key的不存在性证明,key存在时返回KeyExistError
+// 证明中包含从root到前缀路径分叉处的所有节点,分叉节点中的兄弟节点信息可以证明key不在树中
+func (mtp *MTP) ProveAbsence(key []byte) (*Proof, error) {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
+	nodes, leaf, err := mtp.proofNodes(key)
+	if err != nil {
+		return nil, err
+	}
+	if leaf != nil {
+		return nil, KeyExistError
+	}
+	return &Proof{Nodes: nodes}, nil
+}
+
+// 沿着key的前缀路径从root向下查找,返回路径上每个节点的原始数据
+// 如果key存在则返回key对应的叶子节点,否则叶子节点为nil
+func (mtp *MTP) proofNodes(key []byte) (nodes []types.HexBytes, leaf *TrieNode, err error) {
+	data, err := mtp.getValue(mtp.Root)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes = append(nodes, data)
+	node, err := decodeNode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for left := key; ; {
+		if node.Leaf {
+			if len(left) == 0 {
+				return nodes, node, nil
+			}
+			return nodes, nil, nil
+		}
+		son := findSon(node, left)
+		if son == nil || !bytes.HasPrefix(left, son.PathValue) {
+			return nodes, nil, nil
+		}
+		if data, err = mtp.getValue(son.Hash); err != nil {
+			return nil, nil, err
+		}
+		nodes = append(nodes, data)
+		if node, err = decodeNode(data); err != nil {
+			return nil, nil, err
+		}
+		left = left[len(son.PathValue):]
+	}
+}
+
+// 校验key在root下的存在性证明,校验成功返回key对应的value
+func VerifyProof(root, key []byte, proof Proof) ([]byte, error) {
+	leaf, err := walkProof(root, key, proof.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil || len(leaf.Sons) != 1 || !bytes.Equal(crypto.Sha3_256(proof.Value), leaf.Sons[0].Hash) {
+		return nil, InvalidProofError
+	}
+	return proof.Value, nil
+}
+
+// 校验key在root下的不存在性证明
+func VerifyAbsenceProof(root, key []byte, proof Proof) error {
+	leaf, err := walkProof(root, key, proof.Nodes)
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		return KeyExistError
+	}
+	return nil
+}
+
+// 按照proofNodes相同的规则校验证明中的节点,每个节点的hash必须等于父节点中记录的儿子hash
+// key存在时返回叶子节点,key不存在时返回nil,证明不完整或者有多余节点时返回InvalidProofError
+func walkProof(root, key []byte, nodes []types.HexBytes) (*TrieNode, error) {
+	if len(nodes) == 0 || !bytes.Equal(crypto.Sha3_256(nodes[0]), root) {
+		return nil, InvalidProofError
+	}
+	node, err := decodeNode(nodes[0])
+	if err != nil {
+		return nil, InvalidProofError
+	}
+	i, left := 1, key
+	for {
+		if node.Leaf || len(left) == 0 {
+			break
+		}
+		son := findSon(node, left)
+		if son == nil || !bytes.HasPrefix(left, son.PathValue) {
+			break
+		}
+		if i >= len(nodes) || !bytes.Equal(crypto.Sha3_256(nodes[i]), son.Hash) {
+			return nil, InvalidProofError
+		}
+		if node, err = decodeNode(nodes[i]); err != nil || !bytes.Equal(node.PathValue, son.PathValue) {
+			return nil, InvalidProofError
+		}
+		left = left[len(son.PathValue):]
+		i++
+	}
+	if i != len(nodes) {
+		return nil, InvalidProofError
+	}
+	if node.Leaf && len(left) == 0 {
+		return node, nil
+	}
+	return nil, nil
+}
+
+// 同一个节点的儿子节点的PathValue首字节互不相同,所以最多只有一个儿子和key有公共前缀
+func findSon(node *TrieNode, key []byte) *TrieSonInfo {
+	for i := range node.Sons {
+		if PrefixLength(key, node.Sons[i].PathValue) > 0 {
+			return &node.Sons[i]
+		}
+	}
+	return nil
+}
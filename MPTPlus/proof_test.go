@@ -0,0 +1,80 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestMTPProve(t *testing.T) {
+	trie := NewMTP(db.NewMemKVDatabase())
+	keyValues := []KeyValue{
+		{[]byte("HZhouWorld1"), []byte("this is value4")},
+		{[]byte("HZhouxun"), []byte("this is value3")},
+		{[]byte("HelloWorld2"), []byte("this is value1")},
+		{[]byte("HelloX"), []byte("this is value2")},
+		{[]byte("zhouxun"), []byte("this is value8")},
+	}
+	for _, kv := range keyValues {
+		if err := trie.MustInsert(kv.Key, kv.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, kv := range keyValues {
+		proof, err := trie.Prove(kv.Key)
+		if err != nil {
+			t.Fatalf("prove %s failed, %v", kv.Key, err)
+		}
+		value, err := VerifyProof(trie.Root, kv.Key, *proof)
+		if err != nil || !bytes.Equal(value, kv.Value) {
+			t.Fatalf("verify %s failed, value=%s, err=%v", kv.Key, value, err)
+		}
+		if _, err := VerifyProof(trie.Root, []byte("HelloWorld3"), *proof); err == nil {
+			t.Fatalf("proof of %s accepted for another key", kv.Key)
+		}
+		proof.Value = []byte("forged value")
+		if _, err := VerifyProof(trie.Root, kv.Key, *proof); err == nil {
+			t.Fatalf("forged value of %s accepted", kv.Key)
+		}
+	}
+
+	if _, err := trie.Prove([]byte("HelloWorld3")); err != KeyNotExistError {
+		t.Fatalf("prove of absent key should fail, err=%v", err)
+	}
+}
+
+func TestMTPProveAbsence(t *testing.T) {
+	trie := NewMTP(db.NewMemKVDatabase())
+	for _, key := range []string{"HZhouWorld1", "HZhouxun", "HelloWorld2", "HelloX", "zhouxun"} {
+		if err := trie.MustInsert([]byte(key), []byte("value of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 分别覆盖根节点没有匹配的儿子,在中间节点分叉,在叶子节点分叉三种情况
+	for _, key := range []string{"aaaaaaa", "HZhouWorld2", "HelloY", "zhouxum"} {
+		proof, err := trie.ProveAbsence([]byte(key))
+		if err != nil {
+			t.Fatalf("prove absence of %s failed, %v", key, err)
+		}
+		if err := VerifyAbsenceProof(trie.Root, []byte(key), *proof); err != nil {
+			t.Fatalf("verify absence of %s failed, %v", key, err)
+		}
+		if len(proof.Nodes) > 1 {
+			proof.Nodes = proof.Nodes[:len(proof.Nodes)-1]
+			if err := VerifyAbsenceProof(trie.Root, []byte(key), *proof); err == nil {
+				t.Fatalf("truncated absence proof of %s accepted", key)
+			}
+		}
+	}
+
+	if _, err := trie.ProveAbsence([]byte("HelloX")); err != KeyExistError {
+		t.Fatalf("prove absence of existing key should fail, err=%v", err)
+	}
+	proof, _ := trie.Prove([]byte("HelloX"))
+	if err := VerifyAbsenceProof(trie.Root, []byte("HelloX"), *proof); err == nil {
+		t.Fatal("inclusion proof accepted as absence proof")
+	}
+}
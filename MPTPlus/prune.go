@@ -0,0 +1,107 @@
+package MPTPlus
+
+import (
+	"sync"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+// 每个DB记录最近Commit的root的数量,每个区块Commit四棵树
+const recentRootsSize = 256
+
+/*
+*清理只在删除每一批节点时和Commit互斥,标记期间Commit可以继续执行
+*Commit写入的节点可能和清理标记为过期的节点hash相同,例如账户恢复到之前的状态,
+*所以清理标记之前已经Commit的root都需要保留,包括还没有写入链中的区块的root,这些root记录在recentRoots中,
+*标记之后Commit的root记录在CommitWatcher中,每一批删除之前先标记这些root
+ */
+var (
+	pruneLock   sync.RWMutex
+	recentRoots sync.Map // db.IKVDatabase -> *rootRing
+	watchers    sync.Map // db.IKVDatabase -> *CommitWatcher
+)
+
+type rootRing struct {
+	locker sync.Mutex
+	roots  [][]byte
+}
+
+func (ring *rootRing) add(root []byte) {
+	ring.locker.Lock()
+	defer ring.locker.Unlock()
+	if len(ring.roots) >= recentRootsSize {
+		ring.roots = ring.roots[1:]
+	}
+	ring.roots = append(ring.roots, root)
+}
+
+func recordRoot(database db.IKVDatabase, root []byte) {
+	obj, _ := recentRoots.LoadOrStore(database, &rootRing{})
+	obj.(*rootRing).add(root)
+	if obj, exist := watchers.Load(database); exist {
+		obj.(*CommitWatcher).add(root)
+	}
+}
+
+// CommitWatcher记录开始清理之后database上Commit的所有root
+type CommitWatcher struct {
+	database db.IKVDatabase
+	locker   sync.Mutex
+	roots    [][]byte
+}
+
+// 开始记录database上Commit的root,需要在标记之前调用,清理结束之后调用Stop
+func WatchCommits(database db.IKVDatabase) *CommitWatcher {
+	watcher := &CommitWatcher{database: database}
+	watchers.Store(database, watcher)
+	return watcher
+}
+
+func (watcher *CommitWatcher) add(root []byte) {
+	watcher.locker.Lock()
+	defer watcher.locker.Unlock()
+	watcher.roots = append(watcher.roots, root)
+}
+
+// 返回上次Take之后Commit的root,在PauseCommit期间调用时返回的是到目前为止所有新的root
+func (watcher *CommitWatcher) Take() [][]byte {
+	watcher.locker.Lock()
+	defer watcher.locker.Unlock()
+	roots := watcher.roots
+	watcher.roots = nil
+	return roots
+}
+
+func (watcher *CommitWatcher) Stop() {
+	watchers.Delete(watcher.database)
+}
+
+// 返回database最近Commit的root,清理时需要保留它们可以访问到的节点
+func RecentRoots(database db.IKVDatabase) [][]byte {
+	obj, exist := recentRoots.Load(database)
+	if !exist {
+		return nil
+	}
+	ring := obj.(*rootRing)
+	ring.locker.Lock()
+	defer ring.locker.Unlock()
+	return append([][]byte{}, ring.roots...)
+}
+
+// 暂停所有的Commit,返回恢复Commit的函数,清理在删除每一批节点时调用,删除完成之后立即恢复
+func PauseCommit() func() {
+	pruneLock.Lock()
+	return pruneLock.Unlock
+}
+
+// 从database中删除节点,同时删除节点缓存中的副本
+func DeleteNodes(database db.IKVDatabase, hashes [][]byte) error {
+	cache := nodeCache(database)
+	for _, hash := range hashes {
+		if err := database.Delete(hash); err != nil {
+			return err
+		}
+		cache.Delete(hash)
+	}
+	return nil
+}
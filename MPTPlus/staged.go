@@ -0,0 +1,72 @@
+package MPTPlus
+
+/**
+*staged模式用于一次性执行大量插入,例如打包区块和校验区块
+*
+*每次MustInsert都会从叶子节点到root重新生成路径上的所有节点,大部分中间节点很快就会被后续的插入替换掉,
+*staged模式下这些节点只保存在内存中,Commit时只把最终root可以访问到的节点写入DB
+ */
+func (mtp *MTP) Stage() {
+	mtp.Lock.Lock()
+	defer mtp.Lock.Unlock()
+	if mtp.staged == nil {
+		mtp.staged = make(map[string][]byte)
+		mtp.stagedRoot = mtp.Root
+	}
+}
+
+func (mtp *MTP) IsStaged() bool {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
+	return mtp.staged != nil
+}
+
+// 把当前root可以访问到的内存节点写入DB,之后继续保持staged模式
+func (mtp *MTP) Commit() error {
+	mtp.Lock.Lock()
+	defer mtp.Lock.Unlock()
+	if mtp.staged == nil {
+		return nil
+	}
+	pruneLock.RLock()
+	defer pruneLock.RUnlock()
+	if err := mtp.commit(mtp.Root, false); err != nil {
+		return err
+	}
+	recordRoot(mtp.DB, mtp.Root)
+	mtp.staged = make(map[string][]byte)
+	mtp.stagedRoot = mtp.Root
+	return nil
+}
+
+// 丢弃上次Commit之后的所有修改
+func (mtp *MTP) Discard() {
+	mtp.Lock.Lock()
+	defer mtp.Lock.Unlock()
+	if mtp.staged == nil {
+		return
+	}
+	mtp.staged = make(map[string][]byte)
+	mtp.Root = mtp.stagedRoot
+}
+
+// 先写入儿子节点再写入当前节点,保证DB中的节点引用的节点都存在
+// 不在内存中的节点之前已经写入过DB,它的子树也都已经在DB中,不需要再遍历
+func (mtp *MTP) commit(hash []byte, isValue bool) error {
+	data, exist := mtp.staged[string(hash)]
+	if !exist {
+		return nil
+	}
+	if !isValue {
+		node, err := decodeNode(data)
+		if err != nil {
+			return err
+		}
+		for _, son := range node.Sons {
+			if err := mtp.commit(son.Hash, node.Leaf); err != nil {
+				return err
+			}
+		}
+	}
+	return mtp.DB.Set(hash, data)
+}
@@ -3,7 +3,7 @@ package MPTPlus
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
+	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/crypto"
 )
 
@@ -39,14 +39,16 @@ func (mtp *MTP) GetValueByKey(key []byte) (value []byte, err error) {
 		if err != nil {
 			return nil, err
 		} else {
-			return mtp.DB.Get(leaf.Sons[0].Hash)
+			return mtp.getValue(leaf.Sons[0].Hash)
 		}
 	} else {
-		return nil, errors.New("key not exist")
+		return nil, KeyNotExistError
 	}
 }
 
 func (mtp *MTP) ContainsKey(key []byte) bool {
+	mtp.Lock.RLock()
+	defer mtp.Lock.RUnlock()
 	_, prefixs, err := mtp.FindParents(key)
 	if err != nil {
 		return false
@@ -151,6 +153,81 @@ func (mtp *MTP) TryInsert(key, value []byte, parentHashes, prefixs [][]byte) err
 	return nil
 }
 
+/**
+*从root对应的树上删除Key
+*
+*首先删除叶子节点,向上回溯更新Parent节点;如果Parent节点删除之后只剩下一个儿子节点,则把儿子节点
+*合并到Parent节点(PathValue拼接),保证删除之后的root和从未插入过这个Key的root相同
+ */
+func (mtp *MTP) Delete(key []byte) error {
+	mtp.Lock.Lock()
+	defer mtp.Lock.Unlock()
+	parentHashes, prefixs, err := mtp.FindParents(key)
+	if err != nil {
+		return err
+	}
+	if len(parentHashes) == 0 || !bytes.Equal(bytes.Join(prefixs, nil), key) {
+		return KeyNotExistError
+	}
+	leafNode, err := mtp.GetNode(parentHashes[len(parentHashes)-1])
+	if err != nil {
+		return err
+	}
+	if leafNode == nil || !leafNode.Leaf {
+		return KeyNotExistError
+	}
+
+	// removed表示当前节点在Parent中需要被删除,否则用newHash_和newPrefix_替换Parent中的oldPrefix_
+	removed := true
+	oldPrefix_, newPrefix_, newHash_ := leafNode.PathValue, types.HexBytes(nil), []byte(nil)
+	for i := len(parentHashes) - 2; i >= 0; i-- {
+		currentNode, err := mtp.GetNode(parentHashes[i])
+		if err != nil {
+			return err
+		}
+		currentNode.DeleteSon(oldPrefix_)
+		if !removed {
+			currentNode.AddSon(newHash_, newPrefix_)
+		}
+		oldPrefix_ = currentNode.PathValue
+		switch {
+		case len(currentNode.Sons) == 0:
+			removed = true
+		case removed && len(currentNode.Sons) == 1:
+			// 只剩一个儿子节点,把儿子节点合并到当前节点的位置
+			sonNode, err := mtp.GetNode(currentNode.Sons[0].Hash)
+			if err != nil {
+				return err
+			}
+			sonNode.PathValue = append(append(types.HexBytes{}, currentNode.PathValue...), sonNode.PathValue...)
+			if newHash_, err = mtp.SaveNode(*sonNode); err != nil {
+				return err
+			}
+			newPrefix_, removed = sonNode.PathValue, false
+		default:
+			if newHash_, err = mtp.SaveNode(*currentNode); err != nil {
+				return err
+			}
+			newPrefix_, removed = currentNode.PathValue, false
+		}
+	}
+
+	rootNode, err := mtp.GetNode(mtp.Root)
+	if err != nil {
+		return err
+	}
+	rootNode.DeleteSon(oldPrefix_)
+	if !removed {
+		rootNode.AddSon(newHash_, newPrefix_)
+	}
+	if len(rootNode.Sons) == 0 {
+		// 和NewMTP生成的空树保持一致
+		rootNode.Sons = nil
+	}
+	mtp.Root, err = mtp.SaveNode(*rootNode)
+	return err
+}
+
 func (mtp *MTP) FindParents(key []byte) (parentHashes [][]byte, prefixs [][]byte, err error) {
 	left, currentHash := key, mtp.Root
 	var node *TrieNode
@@ -182,17 +259,27 @@ func (mtp *MTP) FindParents(key []byte) (parentHashes [][]byte, prefixs [][]byte
 }
 
 func (mtp *MTP) GetNode(hash []byte) (*TrieNode, error) {
-	data, err := mtp.DB.Get(hash)
+	cache := nodeCache(mtp.DB)
+	if node, exist := cache.Get(hash); exist {
+		return node.clone(), nil
+	}
+	data, err := mtp.getValue(hash)
 	if err != nil || len(data) == 0 {
 		return nil, err
 	}
-	var node TrieNode
-	err = json.Unmarshal(data, &node)
-	return &node, err
+	node, err := decodeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	// staged的节点可能会被丢弃,只缓存已经写入DB的节点
+	if _, staged := mtp.staged[string(hash)]; !staged {
+		cache.Set(hash, node.clone(), int64(len(data))+nodeCacheOverhead)
+	}
+	return node, nil
 }
 
 func (mtp *MTP) SaveNode(node TrieNode) (nodeHash []byte, err error) {
-	data, err := json.Marshal(node)
+	data, err := encodeNode(node, mtp.Encoding)
 	if err != nil {
 		return nil, err
 	}
@@ -201,9 +288,20 @@ func (mtp *MTP) SaveNode(node TrieNode) (nodeHash []byte, err error) {
 
 func (mtp *MTP) SaveValue(value []byte) ([]byte, error) {
 	hash := crypto.Sha3_256(value)
+	if mtp.staged != nil {
+		mtp.staged[string(hash)] = value
+		return hash, nil
+	}
 	return hash, mtp.DB.Set(hash, value)
 }
 
+func (mtp *MTP) getValue(hash []byte) ([]byte, error) {
+	if value, exist := mtp.staged[string(hash)]; exist {
+		return value, nil
+	}
+	return mtp.DB.Get(hash)
+}
+
 //返回公共前缀的长度
 func PrefixLength(a, b []byte) int {
 	length := len(a)
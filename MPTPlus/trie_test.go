@@ -36,10 +36,8 @@ func (this RandomKeyValues) Less(i, j int) bool {
 }
 
 func TestMTPInsertAndGet(t *testing.T) {
-	db, err := db.NewLevelDB("testTrie11")
-	if err != nil {
-		t.Fail()
-	}
+	var err error
+	db := db.NewLevelDB("testTrie11")
 	defer db.DB.Close()
 	var randomKeyValues RandomKeyValues = []KeyValue{
 		KeyValue{[]byte("x"), []byte("this is value9")},
@@ -74,10 +72,8 @@ func TestMTPInsertAndGet(t *testing.T) {
 }
 
 func TestMTPRandomInsert(t *testing.T) {
-	db, err := db.NewLevelDB("testTrie11")
-	if err != nil {
-		t.Fail()
-	}
+	var err error
+	db := db.NewLevelDB("testTrie11")
 	defer db.DB.Close()
 	var randomKeyValues RandomKeyValues = []KeyValue{
 		KeyValue{[]byte("HZhouWorld1"), []byte("this is value4")},
@@ -119,3 +115,78 @@ func TestMTPRandomInsert(t *testing.T) {
 	finishTime := time.Now()
 	fmt.Printf("finishTime=%d\n", finishTime.Nanosecond()-start.Nanosecond())
 }
+
+func TestMTPDelete(t *testing.T) {
+	memDB := db.NewMemKVDatabase()
+	keys := []string{"HZhouWorld1", "HZhouWorld2", "HZhouxun", "HelloWorld1", "HelloWorld2", "HelloX", "x", "zhouxun"}
+	trie := NewMTP(memDB)
+	for _, key := range keys {
+		if err := trie.MustInsert([]byte(key), []byte("value of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, key := range keys {
+		if err := trie.Delete([]byte(key)); err != nil {
+			t.Fatalf("delete %s failed, %v", key, err)
+		}
+		if trie.ContainsKey([]byte(key)) {
+			t.Fatalf("key %s still exists after delete", key)
+		}
+		expect := NewMTP(memDB)
+		for _, left := range keys[i+1:] {
+			expect.MustInsert([]byte(left), []byte("value of "+left))
+			if v, err := trie.GetValue([]byte(left)); err != nil || string(v) != "value of "+left {
+				t.Fatalf("key %s lost after deleting %s", left, key)
+			}
+		}
+		if !bytes.Equal(trie.Root, expect.Root) {
+			t.Fatalf("root mismatch after deleting %s, got %s, expect %s", key, hex.EncodeToString(trie.Root), hex.EncodeToString(expect.Root))
+		}
+	}
+
+	if err := trie.Delete([]byte("HelloX")); err != KeyNotExistError {
+		t.Fatalf("delete absent key should fail, err=%v", err)
+	}
+}
+
+func TestMTPStaged(t *testing.T) {
+	keys := []string{"HZhouWorld1", "HZhouWorld2", "HZhouxun", "HelloWorld1", "HelloX", "x", "zhouxun"}
+	plainDB, stagedDB := db.NewMemKVDatabase(), db.NewMemKVDatabase()
+	plain, staged := NewMTP(plainDB), NewMTP(stagedDB)
+	staged.Stage()
+	for _, key := range keys {
+		plain.MustInsert([]byte(key), []byte("value of "+key))
+		staged.MustInsert([]byte(key), []byte("value of "+key))
+	}
+	if !bytes.Equal(plain.Root, staged.Root) {
+		t.Fatalf("staged root %s differs from plain root %s", hex.EncodeToString(staged.Root), hex.EncodeToString(plain.Root))
+	}
+	if _, err := stagedDB.Get(staged.Root); err == nil {
+		t.Fatal("staged root written before commit")
+	}
+
+	if err := staged.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := MTP_Tree(stagedDB, staged.Root)
+	for _, key := range keys {
+		if v, err := reopened.GetValue([]byte(key)); err != nil || string(v) != "value of "+key {
+			t.Fatalf("key %s not committed, err=%v", key, err)
+		}
+	}
+	count := func(memDB *db.MemKVDatabase) (n int) {
+		memDB.Map.Range(func(k, v interface{}) bool { n++; return true })
+		return
+	}
+	if count(stagedDB) >= count(plainDB) {
+		t.Fatalf("staged db has %d entries, plain db has %d", count(stagedDB), count(plainDB))
+	}
+
+	committed := staged.Root
+	staged.MustInsert([]byte("discarded"), []byte("value"))
+	staged.Discard()
+	if !bytes.Equal(staged.Root, committed) || staged.ContainsKey([]byte("discarded")) {
+		t.Fatal("discard did not restore committed root")
+	}
+}
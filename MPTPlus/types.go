@@ -33,9 +33,14 @@ type TrieNode struct {
 }
 
 type MTP struct {
-	Lock sync.RWMutex
-	Root types.HexBytes
-	DB   db.IKVDatabase
+	Lock     sync.RWMutex
+	Root     types.HexBytes
+	DB       db.IKVDatabase
+	Encoding int // 新节点的编码方式,不影响读取
+
+	// staged模式下新生成的节点只保存在内存中,Commit时才写入DB
+	staged     map[string][]byte
+	stagedRoot types.HexBytes
 }
 
 func (mtp *MTP) UnmarshalJSON(data []byte) error {
@@ -0,0 +1,138 @@
+package MPTPlus
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+)
+
+// VerifyIssue记录一个缺失或者损坏的节点
+type VerifyIssue struct {
+	Hash   types.HexBytes `json:"hash"`
+	Reason string         `json:"reason"`
+}
+
+type VerifyReport struct {
+	Nodes   int64         `json:"nodes"`
+	Values  int64         `json:"values"`
+	Missing []VerifyIssue `json:"missing"`
+	Corrupt []VerifyIssue `json:"corrupt"`
+	locker  sync.Mutex
+}
+
+func (report *VerifyReport) OK() bool {
+	return len(report.Missing) == 0 && len(report.Corrupt) == 0
+}
+
+func (report *VerifyReport) missing(hash []byte, reason string) {
+	report.locker.Lock()
+	report.Missing = append(report.Missing, VerifyIssue{Hash: hash, Reason: reason})
+	report.locker.Unlock()
+}
+
+func (report *VerifyReport) corrupt(hash []byte, reason string) {
+	report.locker.Lock()
+	report.Corrupt = append(report.Corrupt, VerifyIssue{Hash: hash, Reason: reason})
+	report.locker.Unlock()
+}
+
+/**
+*校验roots对应的树在DB中是否完整,直接读取DB中的原始数据,不经过节点缓存
+*
+*每个节点和value的hash必须和数据的Sha3_256相同,节点的儿子必须按照PathValue有序并且互相不是前缀,
+*儿子节点的PathValue必须和父节点中记录的相同。最多同时使用workers个goroutine遍历子树
+ */
+func VerifyTrie(db db.IKVDatabase, roots [][]byte, workers int) *VerifyReport {
+	if workers <= 0 {
+		workers = 1
+	}
+	verifier := &trieVerifier{
+		db:      db,
+		report:  &VerifyReport{Missing: make([]VerifyIssue, 0), Corrupt: make([]VerifyIssue, 0)},
+		visited: &sync.Map{},
+		sem:     make(chan struct{}, workers),
+	}
+	for _, root := range roots {
+		verifier.visit(root, false, nil)
+	}
+	verifier.wg.Wait()
+	return verifier.report
+}
+
+type trieVerifier struct {
+	db      db.IKVDatabase
+	report  *VerifyReport
+	visited *sync.Map
+	sem     chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (verifier *trieVerifier) visit(hash []byte, isValue bool, pathValue []byte) {
+	if _, loaded := verifier.visited.LoadOrStore(string(hash), true); loaded {
+		return
+	}
+	// 只有DB返回不存在时才是缺失的节点,空的value需要和其他数据一样校验hash
+	data, err := verifier.db.Get(hash)
+	if db.IsNotFound(err) {
+		verifier.report.missing(hash, "not found in database")
+		return
+	} else if err != nil {
+		verifier.report.corrupt(hash, "read failed, "+err.Error())
+		return
+	}
+	if crypto.Validate(data, hash) != nil {
+		verifier.report.corrupt(hash, "hash mismatch")
+		return
+	}
+	if isValue {
+		atomic.AddInt64(&verifier.report.Values, 1)
+		return
+	}
+	atomic.AddInt64(&verifier.report.Nodes, 1)
+
+	node, err := decodeNode(data)
+	if err != nil {
+		verifier.report.corrupt(hash, "can not decode node")
+		return
+	}
+	if pathValue != nil && !bytes.Equal(node.PathValue, pathValue) {
+		verifier.report.corrupt(hash, "pathValue differs from parent")
+	}
+	if node.Leaf {
+		if len(node.Sons) != 1 || len(node.Sons[0].PathValue) != 0 {
+			verifier.report.corrupt(hash, "leaf node must have exactly one value")
+			return
+		}
+		verifier.visit(node.Sons[0].Hash, true, nil)
+		return
+	}
+	for i, son := range node.Sons {
+		if len(son.PathValue) == 0 {
+			verifier.report.corrupt(hash, "empty son pathValue")
+		} else if i > 0 && bytes.Compare(node.Sons[i-1].PathValue, son.PathValue) >= 0 {
+			verifier.report.corrupt(hash, "sons are not sorted")
+		} else if i > 0 && bytes.HasPrefix(son.PathValue, node.Sons[i-1].PathValue) {
+			verifier.report.corrupt(hash, "sons are not prefix-free")
+		}
+	}
+	for _, son := range node.Sons {
+		son := son
+		select {
+		case verifier.sem <- struct{}{}:
+			verifier.wg.Add(1)
+			go func() {
+				defer func() {
+					<-verifier.sem
+					verifier.wg.Done()
+				}()
+				verifier.visit(son.Hash, false, son.PathValue)
+			}()
+		default:
+			verifier.visit(son.Hash, false, son.PathValue)
+		}
+	}
+}
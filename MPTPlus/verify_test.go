@@ -0,0 +1,41 @@
+package MPTPlus
+
+import (
+	"testing"
+
+	"github.com/OpenOCC/OCC/db"
+)
+
+func TestVerifyTrie(t *testing.T) {
+	memDB := db.NewMemKVDatabase()
+	trie := NewMTP(memDB)
+	for _, key := range []string{"HZhouWorld1", "HZhouWorld2", "HZhouxun", "HelloWorld1", "HelloX", "x", "zhouxun"} {
+		trie.MustInsert([]byte(key), []byte("value of "+key))
+	}
+	report := VerifyTrie(memDB, [][]byte{trie.Root}, 4)
+	if !report.OK() || report.Values != 7 {
+		t.Fatalf("verify healthy trie failed, %+v", report)
+	}
+
+	proof, _ := trie.Prove([]byte("HelloX"))
+	leaf, _ := decodeNode(proof.Nodes[len(proof.Nodes)-1])
+	memDB.Delete(leaf.Sons[0].Hash)
+	memDB.Set(trie.Root, append(append([]byte{}, proof.Nodes[0]...), ' '))
+	report = VerifyTrie(memDB, [][]byte{trie.Root}, 4)
+	if len(report.Corrupt) != 1 || len(report.Missing) != 0 {
+		t.Fatalf("corrupt root not reported, %+v", report)
+	}
+	memDB.Set(trie.Root, proof.Nodes[0])
+	report = VerifyTrie(memDB, [][]byte{trie.Root}, 4)
+	if len(report.Missing) != 1 || len(report.Corrupt) != 0 {
+		t.Fatalf("missing value not reported, %+v", report)
+	}
+
+	// 合法存储的空value不是缺失的节点
+	empty := NewMTP(memDB)
+	empty.MustInsert([]byte("empty"), []byte{})
+	report = VerifyTrie(memDB, [][]byte{empty.Root}, 1)
+	if !report.OK() || report.Values != 1 {
+		t.Fatalf("empty value reported as broken, %+v", report)
+	}
+}
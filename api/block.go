@@ -4,7 +4,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/OpenOCC/OCC/MPTPlus"
 	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/encapdb"
 	"github.com/OpenOCC/OCC/node"
 	"github.com/OpenOCC/xserver/x_err"
@@ -19,6 +21,8 @@ func init() {
 	x_router.Get("/block/api/getHeaderByHash", getHeaderByHash)
 	x_router.Get("/block/api/getBlockByHeight", getBlockByHeight)
 	x_router.Post("/block/api/blockFromPeer", broadcast, blockFromPeer)
+	x_router.Get("/block/api/stateDiff", stateDiff)
+	x_router.Get("/block/api/finalized", finalized)
 }
 
 func getBlockByHeight(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -27,9 +31,13 @@ func getBlockByHeight(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	if block == nil {
 		return x_resp.Fail(-1, "not found", nil), nil
 	}
+	message := blockchain.NewBlockMessage(*block)
+	if message == nil {
+		return x_resp.Fail(-1, "block body not found", nil), nil
+	}
 	return &x_resp.XRespContainer{
 		HttpCode: 200,
-		Body:     block.Bytes(),
+		Body:     message.Bytes(),
 	}, nil
 }
 
@@ -56,12 +64,44 @@ func getHeaderByHeight(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 }
 
 func blockFromPeer(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
-	var block blockchain.Block
-	json.Unmarshal(req.Body, &block)
+	var message blockchain.BlockMessage
+	json.Unmarshal(req.Body, &message)
+	block := message.ToBlock()
+	if block == nil {
+		return x_resp.Fail(-1, "error invalid block body", nil), nil
+	}
 	lastHeight := node.GetMainChain().GetLastHeight()
 	if lastHeight+1 != block.GetHeader().Height {
 		return x_resp.Fail(-1, "error invalid height", nil), nil
 	}
-	node.BlockFromPeer(block)
+	node.BlockFromPeer(*block)
 	return x_resp.Return("recieved", nil)
 }
+
+// 返回指定高度的区块相对于上一个区块修改过的账户
+func stateDiff(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	height := req.MustGetInt64("height")
+	if height <= 0 {
+		return x_resp.Fail(-1, "invalid height", nil), nil
+	}
+	last, current := encapdb.GetHeaderByHeight(1, height-1), encapdb.GetHeaderByHeight(1, height)
+	if last == nil || current == nil {
+		return x_resp.Fail(-1, "not found", nil), nil
+	}
+	changes, err := MPTPlus.Diff(db.GetDBInst(), last.StatTree.Root, current.StatTree.Root)
+	return x_resp.Return(changes, err)
+}
+
+// 返回不可逆的最高高度和对应的区块头
+func finalized(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	height := encapdb.GetFinalizedHeight(1)
+	header := encapdb.GetHeaderByHeight(1, height)
+	if header == nil {
+		return x_resp.Fail(-1, "not found", nil), nil
+	}
+	return x_resp.Return(map[string]interface{}{
+		"height":     height,
+		"headerHash": hex.EncodeToString(header.CaculateHash()),
+		"header":     header,
+	}, nil)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 
+	"github.com/OpenOCC/OCC/MPTPlus"
 	"github.com/OpenOCC/OCC/crypto"
 	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/log"
@@ -18,6 +19,7 @@ import (
 func init() {
 	x_router.Post("/db/api/get", GetValue)
 	x_router.Get("/db/api/getByHex", GetValueByHexHash)
+	x_router.Get("/db/api/cacheStats", cacheStats)
 }
 
 var (
@@ -61,3 +63,13 @@ func validate(k, v []byte, err error) (*x_resp.XRespContainer, *x_err.XErr) {
 		Body:     v,
 	}, nil
 }
+
+func cacheStats(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	stats := map[string]interface{}{
+		"node": MPTPlus.NodeCacheStats(),
+	}
+	if composed, ok := db.GetDBInst().(*db.ComposedKVDatabase); ok {
+		stats["db"] = composed.CacheStats()
+	}
+	return x_resp.Return(stats, nil)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/OpenOCC/OCC/conf"
+	"github.com/OpenOCC/OCC/consensus"
 	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/node"
 	"github.com/OpenOCC/OCC/param"
@@ -19,10 +20,11 @@ func init() {
 	x_router.All("/peer/api/ping", ping)
 	x_router.Post("/peer/api/peers", delegatePeers)
 	x_router.Post("/peer/api/heartbeat", heartbeat)
+	x_router.Get("/peer/api/liveness", liveness)
 }
 
 func delegatePeers(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
-	peers := param.MainChainDelegateNode
+	peers := consensus.DelegatesOf(node.GetMainChain().LastHeader())
 	return x_resp.Success(peers), x_err.NewXErr(nil)
 }
 
@@ -32,10 +34,16 @@ func heartbeat(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	if err != nil {
 		return x_resp.Return(nil, err)
 	}
-	node.GetInst().Heartbeat(heartbeat)
+	if !node.GetInst().Heartbeat(heartbeat) {
+		return x_resp.Fail(-1, "invalid heartbeat", nil), nil
+	}
 	return x_resp.Return(nil, nil)
 }
 
+func liveness(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	return x_resp.Return(node.GetInst().Liveness(), nil)
+}
+
 func ping(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	resp := &x_resp.XRespContainer{
 		HttpCode: 200,
@@ -1,22 +1,93 @@
 package api
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"github.com/OpenOCC/OCC/blockchain"
 	"github.com/OpenOCC/OCC/core/userevent"
 	"github.com/OpenOCC/OCC/crypto"
 	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/dispatcher"
+	"github.com/OpenOCC/OCC/encapdb"
 	"github.com/OpenOCC/OCC/node"
 	"github.com/OpenOCC/xserver/x_err"
 	"github.com/OpenOCC/xserver/x_http/x_req"
 	"github.com/OpenOCC/xserver/x_http/x_resp"
 	"github.com/OpenOCC/xserver/x_http/x_router"
+	"strings"
 )
 
 func init() {
 	x_router.Get("/transaction/api/fee", fee)
 	x_router.Post("/transaction/api/newTransaction", broadcast, newTransaction)
 	x_router.Get("/transaction/api/userTxs", userTxs)
+	x_router.Get("/transaction/api/get", getTransaction)
+	x_router.Get("/transaction/api/proof", txProof)
+}
+
+const (
+	TX_STATUS_PENDING  = "pending"
+	TX_STATUS_INCLUDED = "included"
+	TX_STATUS_FAILED   = "failed"
+	TX_STATUS_UNKNOWN  = "unknown"
+)
+
+// 根据newTransaction返回的交易id查询交易状态,已经打包的交易同时返回高度、位置和receipt
+func getTransaction(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	id := strings.ToLower(req.MustGetString("id"))
+	if location := encapdb.GetTxLocation(1, id); location != nil {
+		status := TX_STATUS_INCLUDED
+		if !location.Receipt.Success {
+			status = TX_STATUS_FAILED
+		}
+		return x_resp.Return(map[string]interface{}{
+			"status":   status,
+			"location": location,
+		}, nil)
+	}
+	if tx := node.GetMainChain().Pool.Get(id); tx != nil {
+		return x_resp.Return(map[string]interface{}{
+			"status":      TX_STATUS_PENDING,
+			"transaction": tx,
+		}, nil)
+	}
+	return x_resp.Return(map[string]interface{}{
+		"status": TX_STATUS_UNKNOWN,
+	}, nil)
+}
+
+// 返回已经打包的交易在区块交易默克尔树中的证明,轻节点根据区块头中的TxHash校验单个交易
+// HEADER_VERSION_TX_MERKLE之前的区块不支持交易证明
+func txProof(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	id := strings.ToLower(req.MustGetString("id"))
+	location := encapdb.GetTxLocation(1, id)
+	if location == nil {
+		return x_resp.Fail(-1, "not found", nil), nil
+	}
+	block := encapdb.GetBlockByHeight(1, location.Height)
+	if block == nil || !bytes.Equal(block.Hash, location.BlockHash) {
+		return x_resp.Fail(-1, "block not found", nil), nil
+	}
+	header := block.GetHeader()
+	txs, receipts := block.GetTransactions(), block.GetTxReceipts()
+	if header == nil || txs == nil || receipts == nil || len(txs) != len(receipts) {
+		return x_resp.Fail(-1, "block body not found", nil), nil
+	}
+	if header.Version < blockchain.HEADER_VERSION_TX_MERKLE {
+		return x_resp.Fail(-1, "block does not support transaction proof", nil), nil
+	}
+	proof := blockchain.ProveTx(blockchain.TxLeaves(txs, receipts), location.Index)
+	if proof == nil {
+		return x_resp.Fail(-1, "invalid transaction index", nil), nil
+	}
+	return x_resp.Return(map[string]interface{}{
+		"headerHash":  hex.EncodeToString(block.Hash),
+		"header":      header,
+		"transaction": txs[location.Index],
+		"receipt":     receipts[location.Index],
+		"proof":       proof,
+	}, nil)
 }
 
 func fee(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -2,6 +2,10 @@ package api
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"github.com/OpenOCC/OCC/MPTPlus"
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/encapdb"
 	"github.com/OpenOCC/OCC/node"
 	"github.com/OpenOCC/xserver/x_err"
 	"github.com/OpenOCC/xserver/x_http/x_req"
@@ -12,8 +16,16 @@ import (
 func init() {
 	x_router.Get("/account/api/info", userInfo)
 	x_router.Get("/account/api/nonce", userNonce)
+	x_router.Get("/account/api/proof", userProof)
+	x_router.Get("/account/api/list", listAccounts)
+	x_router.Get("/account/api/history", accountHistory)
 }
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
 func userInfo(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	address := req.MustGetString("address")
 	hexAddress, err := hex.DecodeString(address)
@@ -48,3 +60,105 @@ func userNonce(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 
 	return x_resp.Return(nonce, nil)
 }
+
+// 返回账户在StatTree下的存在性证明,如果账户不存在则返回不存在性证明
+// 默认使用最新的区块头,传入height时使用指定高度的区块头,钱包可以根据已知的区块头hash进行校验
+func userProof(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	address := req.MustGetString("address")
+	hexAddress, err := hex.DecodeString(address)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	header := node.GetMainChain().LastHeader()
+	if _, exist := req.GetParam("height"); exist {
+		h := node.GetBlockByHeight(1, req.MustGetInt64("height"))
+		if h == nil {
+			return x_resp.Fail(-1, "not found", nil), nil
+		}
+		header = *h
+	}
+	exist := header.StatTree.ContainsKey(hexAddress)
+	var proof *MPTPlus.Proof
+	if exist {
+		proof, err = header.StatTree.Prove(hexAddress)
+	} else {
+		proof, err = header.StatTree.ProveAbsence(hexAddress)
+	}
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(map[string]interface{}{
+		"headerHash": hex.EncodeToString(header.CaculateHash()),
+		"header":     header,
+		"exist":      exist,
+		"proof":      proof,
+	}, nil)
+}
+
+// 按照地址顺序分页返回最新区块中的账户,cursor为上一页返回的next,为空表示从头开始
+func listAccounts(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	var start []byte
+	if _, exist := req.GetParam("cursor"); exist {
+		cursor, err := hex.DecodeString(req.MustGetString("cursor"))
+		if err != nil {
+			return x_resp.Return(nil, err)
+		}
+		start = cursor
+	}
+	limit := defaultPageSize
+	if _, exist := req.GetParam("limit"); exist {
+		limit = int(req.MustGetInt64("limit"))
+	}
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	accounts := make([]types.Account, 0, limit)
+	var next types.HexBytes
+	err := node.GetMainChain().LastHeader().StatTree.Iterate(start, nil, func(key, value []byte) bool {
+		if len(accounts) == limit {
+			next = append(next, key...)
+			return false
+		}
+		var account types.Account
+		if json.Unmarshal(value, &account) == nil {
+			accounts = append(accounts, account)
+		}
+		return true
+	})
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	return x_resp.Return(map[string]interface{}{
+		"accounts": accounts,
+		"next":     next,
+	}, nil)
+}
+
+// 地址已经打包的交易记录,从新到旧分页返回,next作为下一页的cursor
+func accountHistory(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	address, err := hex.DecodeString(req.MustGetString("address"))
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	cursor := int64(-1)
+	if _, exist := req.GetParam("cursor"); exist {
+		cursor = req.MustGetInt64("cursor")
+	}
+	limit := defaultPageSize
+	if _, exist := req.GetParam("limit"); exist {
+		limit = int(req.MustGetInt64("limit"))
+	}
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	entries, next := encapdb.GetHistory(1, hex.EncodeToString(address), cursor, limit)
+	result := map[string]interface{}{
+		"entries": entries,
+	}
+	if next >= 0 {
+		result["next"] = next
+	}
+	return x_resp.Return(result, nil)
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"github.com/OpenOCC/OCC/encapdb"
 	"github.com/OpenOCC/OCC/node"
 
 	"github.com/OpenOCC/OCC/blockchain"
@@ -15,6 +17,8 @@ func init() {
 	x_router.Post("/vote/api/vote", voteBlock)
 	x_router.Post("/vote/api/voteResult", voteResult)
 	x_router.Get("/vote/api/getVotes", getVotes)
+	x_router.Post("/vote/api/skip", skipVote)
+	x_router.Get("/vote/api/evidence", getEvidence)
 }
 
 func voteBlock(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
@@ -45,3 +49,42 @@ func getVotes(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
 	votes := node.GetVoteResults(1, blockHash)
 	return x_resp.Return(votes, nil)
 }
+
+func skipVote(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	var vote blockchain.PeerSkipVote
+	err := json.Unmarshal(req.Body, &vote)
+	if err != nil {
+		return x_resp.Return(nil, err)
+	}
+	if !vote.Validate() {
+		return x_resp.Return(false, nil)
+	}
+	node.SkipVoteFromPeer(vote)
+	return nil, nil
+}
+
+// 查询委托人的作恶证据,指定key时只返回对应的证据
+func getEvidence(req *x_req.XReq) (*x_resp.XRespContainer, *x_err.XErr) {
+	if _, exist := req.GetParam("key"); exist {
+		evidence := encapdb.GetEvidence(1, req.MustGetString("key"))
+		if evidence == nil {
+			return x_resp.Fail(-1, "not found", nil), nil
+		}
+		return x_resp.Return(evidenceResult(*evidence), nil)
+	}
+	evidences := encapdb.GetEvidences(1)
+	result := make([]map[string]interface{}, 0, len(evidences))
+	for _, evidence := range evidences {
+		result = append(result, evidenceResult(evidence))
+	}
+	return x_resp.Return(result, nil)
+}
+
+func evidenceResult(evidence blockchain.Evidence) map[string]interface{} {
+	return map[string]interface{}{
+		"key":            evidence.Key(),
+		"hash":           hex.EncodeToString(evidence.Hash()),
+		"evidence":       evidence,
+		"includedHeight": encapdb.GetEvidenceIncludedHeight(1, evidence.Key()),
+	}
+}
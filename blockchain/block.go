@@ -1,12 +1,15 @@
 package blockchain
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/core/userevent"
 	"github.com/OpenOCC/OCC/crypto"
 	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+	"strings"
 )
 
 const EMPTY_TX = "ca4510738395af1429224dd785675309c344b2b549632e20275c69b15ed1d210"
@@ -25,6 +28,8 @@ type Block struct {
 	Miner               types.Peer             `json:"miner"`
 	Transactions        userevent.Transactions `json:"-"`
 	TransactionReceipts userevent.Receipts     `json:"-"`
+	Evidences           Evidences              `json:"evidences,omitempty"`
+	BodyHash            types.HexBytes         `json:"bodyHash,omitempty"` // 交易列表的Sha3,区块体按照这个hash保存在本地数据库
 }
 
 func GetBlockFromBytes(data []byte) *Block {
@@ -36,17 +41,37 @@ func GetBlockFromBytes(data []byte) *Block {
 	return &block
 }
 
-func (block Block) GetTransactions() []userevent.Transaction {
-	if hex.EncodeToString(block.GetHeader().TxHash) == EMPTY_TX {
-		return []userevent.Transaction{}
-	} else if len(block.Transactions) == 0 {
-		body, err := block.Miner.GetDBValue(hex.EncodeToString(block.GetHeader().TxHash))
+// 没有交易的区块不需要读取区块体,创世块的TxHash为空
+func (block *Block) isEmpty() bool {
+	header := block.GetHeader()
+	return header != nil && (len(header.TxHash) == 0 || hex.EncodeToString(block.bodyKey()) == EMPTY_TX)
+}
+
+// 区块体在本地数据库中的key,HEADER_VERSION_TX_MERKLE之前的区块TxHash就是交易列表的Sha3
+// 之后的TxHash是Merkle根,不是区块体内容的hash,只能使用BodyHash
+func (block *Block) bodyKey() []byte {
+	if len(block.BodyHash) != 0 {
+		return block.BodyHash
+	}
+	if header := block.GetHeader(); header != nil && header.Version < HEADER_VERSION_TX_MERKLE {
+		return header.TxHash
+	}
+	return nil
+}
+
+// 区块体随区块一起传播并写入本地数据库,不再从Miner获取
+func (block *Block) GetTransactions() []userevent.Transaction {
+	if block.Transactions != nil {
+		return block.Transactions
+	} else if block.isEmpty() {
+		block.Transactions = []userevent.Transaction{}
+	} else if key := block.bodyKey(); key != nil {
+		body, err := db.GetDBInst().Get(key)
 		if err != nil {
 			return nil
 		}
-		var txs []userevent.Transaction
-		err = json.Unmarshal(body, &txs)
-		if err != nil {
+		var txs userevent.Transactions
+		if err = json.Unmarshal(body, &txs); err != nil {
 			return nil
 		}
 		block.Transactions = txs
@@ -54,17 +79,18 @@ func (block Block) GetTransactions() []userevent.Transaction {
 	return block.Transactions
 }
 
-func (block Block) GetTxReceipts() []userevent.TransactionReceipt {
-	if hex.EncodeToString(block.GetHeader().TxHash) == EMPTY_TX {
-		return []userevent.TransactionReceipt{}
-	} else if len(block.TransactionReceipts) == 0 {
-		body, err := block.Miner.GetDBValue(hex.EncodeToString(block.GetHeader().ReceiptHash))
+func (block *Block) GetTxReceipts() []userevent.TransactionReceipt {
+	if block.TransactionReceipts != nil {
+		return block.TransactionReceipts
+	} else if block.isEmpty() {
+		block.TransactionReceipts = []userevent.TransactionReceipt{}
+	} else if header := block.GetHeader(); header != nil {
+		body, err := db.GetDBInst().Get(header.ReceiptHash)
 		if err != nil {
 			return nil
 		}
-		var receipts []userevent.TransactionReceipt
-		err = json.Unmarshal(body, &receipts)
-		if err != nil {
+		var receipts userevent.Receipts
+		if err = json.Unmarshal(body, &receipts); err != nil {
 			return nil
 		}
 		block.TransactionReceipts = receipts
@@ -72,11 +98,9 @@ func (block Block) GetTxReceipts() []userevent.TransactionReceipt {
 	return block.TransactionReceipts
 }
 
-func (block Block) GetHeader() *Header {
-	if block.header != nil {
-		return block.header
-	} else {
-		data, err := block.Miner.GetDBValue(hex.EncodeToString(block.Hash))
+func (block *Block) GetHeader() *Header {
+	if block.header == nil {
+		data, err := db.GetDBInst().Get(block.Hash)
 		if err != nil {
 			return nil
 		}
@@ -98,10 +122,32 @@ func (block *Block) NewTransaction(tx userevent.Transaction) *userevent.Transact
 	return &receipt
 }
 
+// 把作恶证据打包到区块中,需要在打包交易之前调用
+func (block *Block) NewEvidence(evidence Evidence) bool {
+	if !evidence.Validate() || len(block.Evidences) >= MAX_EVIDENCES_PER_BLOCK {
+		return false
+	}
+	if err := block.header.ApplyEvidence(evidence); err != nil {
+		return false
+	}
+	block.Evidences = append(block.Evidences, evidence)
+	return true
+}
+
 func (block *Block) Finish() {
+	if len(block.Evidences) > 0 {
+		block.header.EvidenceHash = block.Evidences.Hash()
+	}
+	if err := block.header.UpdateDelegates(); err != nil {
+		log.Crit("Update delegates failed, %s", err.Error())
+	}
 	block.header.UpdateMiner()
-	block.header.TxHash = crypto.Sha3_256(block.Transactions.Bytes())
-	db.GetDBInst().Set(block.header.TxHash, block.Transactions.Bytes())
+	if err := block.header.Commit(); err != nil {
+		log.Crit("Commit block stat failed, %s", err.Error())
+	}
+	block.header.TxHash = CaculateTxHash(block.header.Version, block.Transactions, block.TransactionReceipts)
+	block.BodyHash = crypto.Sha3_256(block.Transactions.Bytes())
+	db.GetDBInst().Set(block.BodyHash, block.Transactions.Bytes())
 	block.header.ReceiptHash = crypto.Sha3_256(block.TransactionReceipts.Bytes())
 	db.GetDBInst().Set(block.header.ReceiptHash, block.TransactionReceipts.Bytes())
 	block.Hash = block.header.CaculateHash()
@@ -113,15 +159,89 @@ func (block *Block) Sign(privKey []byte) error {
 	return err
 }
 
+// 校验区块的签名是否由Miner的私钥生成
+func (block Block) ValidateSign() bool {
+	pubKey, err := crypto.RecoverPubKey(block.Hash, block.Signature)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(types.FromPubKeyToAddress(pubKey)), block.Miner.Account)
+}
+
 func (block Block) Bytes() []byte {
 	data, _ := json.Marshal(block)
 	return data
 }
 
+/*
+*BlockMessage是区块在节点之间传播的格式,区块头、交易和回执和区块一起发送
+*接收方通过ToBlock校验区块头的hash以及交易和回执的TxHash、ReceiptHash,TxHash的计算方式由区块头的版本决定
+*区块写入链中时由encapdb把区块头和区块体保存到本地数据库,区块体的key由接收方根据交易重新计算
+ */
+type BlockMessage struct {
+	Block        Block                  `json:"block"`
+	Header       *Header                `json:"header"`
+	Transactions userevent.Transactions `json:"transactions"`
+	Receipts     userevent.Receipts     `json:"receipts"`
+}
+
+// 本地缺少区块头或者区块体时返回nil
+func NewBlockMessage(block Block) *BlockMessage {
+	header, txs, receipts := block.GetHeader(), block.GetTransactions(), block.GetTxReceipts()
+	if header == nil || txs == nil || receipts == nil {
+		return nil
+	}
+	return &BlockMessage{
+		Block:        block,
+		Header:       header,
+		Transactions: txs,
+		Receipts:     receipts,
+	}
+}
+
+func GetBlockMessageFromBytes(data []byte) *BlockMessage {
+	var message BlockMessage
+	err := json.Unmarshal(data, &message)
+	if err != nil {
+		return nil
+	}
+	return &message
+}
+
+func (message BlockMessage) Bytes() []byte {
+	data, _ := json.Marshal(message)
+	return data
+}
+
+// 校验通过之后返回带有区块头和区块体的区块,否则返回nil
+func (message BlockMessage) ToBlock() *Block {
+	if message.Header == nil || !bytes.Equal(message.Header.CaculateHash(), message.Block.Hash) {
+		return nil
+	}
+	txs, receipts := message.Transactions, message.Receipts
+	if txs == nil {
+		txs = userevent.Transactions{}
+	}
+	if receipts == nil {
+		receipts = userevent.Receipts{}
+	}
+	if !bytes.Equal(CaculateTxHash(message.Header.Version, txs, receipts), message.Header.TxHash) ||
+		!bytes.Equal(crypto.Sha3_256(receipts.Bytes()), message.Header.ReceiptHash) {
+		return nil
+	}
+	block := message.Block
+	block.header = message.Header
+	block.BodyHash = crypto.Sha3_256(txs.Bytes())
+	block.Transactions = txs
+	block.TransactionReceipts = receipts
+	return &block
+}
+
 func CreateGenesisBlock(accounts []types.Account) Block {
 	header := GenesisHeader(accounts)
 	block := Block{
 		header: header,
+		Hash:   header.CaculateHash(),
 	}
 	return block
 }
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func TestBlockMessage(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	enableAllVersions()
+	defer SetVersionHeights(nil)
+
+	accounts := make([]types.Account, 0)
+	for i := 0; i < 2; i++ {
+		account := types.CreateAccount(crypto.Sha3_256([]byte{byte(i)}), 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+	}
+	genesis := CreateGenesisBlock(accounts)
+	block := CreateBlock(*genesis.GetHeader(), types.Peer{Account: hex.EncodeToString(accounts[0].Address)})
+	tx := userevent.NewTransaction(accounts[0].Address, accounts[1].Address, 0, 10, 1, 1, "", "")
+	if receipt := block.NewTransaction(*tx); receipt == nil || !receipt.Success {
+		t.Fatal("transaction should succeed")
+	}
+	block.Finish()
+	// TxHash是Merkle根,区块体只按照内容的hash保存
+	if !bytes.Equal(block.BodyHash, crypto.Sha3_256(block.Transactions.Bytes())) {
+		t.Fatal("block body should be keyed by its content hash")
+	}
+	if _, err := db.GetDBInst().Get(block.GetHeader().TxHash); err == nil {
+		t.Fatal("block body should not be stored under the Merkle root")
+	}
+
+	message := GetBlockMessageFromBytes(NewBlockMessage(*block).Bytes())
+	received := message.ToBlock()
+	if received == nil || len(received.GetTransactions()) != 1 || len(received.GetTxReceipts()) != 1 {
+		t.Fatal("message with matching body should be accepted")
+	}
+	forged := *message
+	forged.Block.BodyHash = crypto.Sha3_256([]byte("body"))
+	if block := forged.ToBlock(); block == nil || !bytes.Equal(block.BodyHash, received.BodyHash) {
+		t.Fatal("body hash should be computed by the receiver")
+	}
+
+	forged = *message
+	forged.Transactions = userevent.Transactions{*userevent.NewTransaction(accounts[0].Address, accounts[1].Address, 0, 20, 1, 1, "", "")}
+	if forged.ToBlock() != nil {
+		t.Fatal("message with transactions not matching TxHash should be rejected")
+	}
+	forged = *message
+	forged.Receipts = nil
+	if forged.ToBlock() != nil {
+		t.Fatal("message with receipts not matching ReceiptHash should be rejected")
+	}
+	forged = *message
+	header := *message.Header
+	header.TotalFee++
+	forged.Header = &header
+	if forged.ToBlock() != nil {
+		t.Fatal("message with header not matching block hash should be rejected")
+	}
+
+	// 区块体只从本地数据库读取
+	stored := GetBlockFromBytes(block.Bytes())
+	if stored.GetHeader() != nil || NewBlockMessage(*stored) != nil {
+		t.Fatal("block without local header should not be served")
+	}
+	db.GetDBInst().Set(block.Hash, block.GetHeader().Bytes())
+	stored = GetBlockFromBytes(block.Bytes())
+	if txs := stored.GetTransactions(); len(txs) != 1 || txs[0].TransactionId() != tx.TransactionId() {
+		t.Fatalf("transactions should be read from local database, got %v", txs)
+	}
+}
+
+func TestValidateBlockStatSignature(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	defer userevent.SetLegacySignHeight(0)
+
+	pub, priv := crypto.GenerateKeyPair()
+	account := types.CreateAccount(types.FromPubKeyToAddress(pub), 100)
+	account.Gas = 100
+	genesisBlock := CreateGenesisBlock([]types.Account{account})
+	genesis := *genesisBlock.GetHeader()
+	miner := types.Peer{Account: hex.EncodeToString(account.Address)}
+	pack := func(tx userevent.Transaction) *Block {
+		block := CreateBlock(genesis, miner)
+		if receipt := block.NewTransaction(tx); receipt == nil || !receipt.Success {
+			t.Fatal("transaction should succeed")
+		}
+		block.Finish()
+		return block
+	}
+	tx := userevent.NewTransaction(account.Address, crypto.Sha3_256([]byte("to")), 0, 10, 1, 1, "", "")
+	legacy := *tx
+	legacy.Sign, _ = crypto.Crypto(legacy.Msg(), priv)
+	binary := *tx
+	if err := userevent.SignTransaction(&binary, 1, priv); err != nil {
+		t.Fatal(err)
+	}
+
+	// 高度1开始不接受旧格式签名,打包节点绕过交易池写入的旧签名交易也要被拒绝
+	userevent.SetLegacySignHeight(1)
+	block := pack(legacy)
+	if genesis.ValidateBlockStat(1, *block.GetHeader(), block.Transactions, block.TransactionReceipts, nil) {
+		t.Fatal("block with a legacy signed transaction after the cutoff should be rejected")
+	}
+	block = pack(binary)
+	if !genesis.ValidateBlockStat(1, *block.GetHeader(), block.Transactions, block.TransactionReceipts, nil) {
+		t.Fatal("block with a binary signed transaction should be valid")
+	}
+	if genesis.ValidateBlockStat(2, *block.GetHeader(), block.Transactions, block.TransactionReceipts, nil) {
+		t.Fatal("transaction signed for another chain should be rejected")
+	}
+}
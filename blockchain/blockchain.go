@@ -8,6 +8,7 @@ import (
 	"github.com/OpenOCC/OCC/core/userevent"
 	"github.com/OpenOCC/OCC/log"
 	"github.com/OpenOCC/OCC/pool"
+	"github.com/OpenOCC/OCC/util"
 )
 
 const (
@@ -22,6 +23,7 @@ type BlockChain struct {
 	Locker        sync.RWMutex
 	Pool          *pool.TxPool
 	PackLock      sync.RWMutex
+	Clock         util.Clock
 }
 
 func NewBlockChain(chainId int64) *BlockChain {
@@ -31,6 +33,7 @@ func NewBlockChain(chainId int64) *BlockChain {
 		currentLocker: sync.RWMutex{},
 		Pool:          pool.NewTxPool(),
 		PackLock:      sync.RWMutex{},
+		Clock:         util.RealClock{},
 	}
 }
 
@@ -59,36 +62,44 @@ func (chain *BlockChain) PackTime() time.Duration {
 
 func (chain *BlockChain) PackTransaction(ctxlog *ctxlog.ContextLog, block *Block) {
 	defer block.Finish()
-	eventTimeout := time.After(chain.PackTime())
+	start := chain.Clock.Now().UnixNano()
+	eventTimeout := chain.Clock.After(chain.PackTime())
 
-	start := time.Now().UnixNano()
 	started := false
 	numTx := 0
 	for {
+		// 先取一次交易池再判断超时,FakeClock的After会立即返回
+		txs := chain.Pool.Pop(20)
+		if len(txs) > 0 {
+			if !started {
+				started = true
+				start = chain.Clock.Now().UnixNano()
+			}
+			for _, tx := range txs {
+				// 交易池中的交易可能在打包高度已经不接受旧格式签名,签名无效的交易不打包
+				if !userevent.ValidateTransaction(*tx, chain.ChainId, block.GetHeader().Height) {
+					log.Info("Drop transaction %s with invalid signature.", tx.TransactionId())
+					continue
+				}
+				block.NewTransaction(*tx)
+			}
+			numTx += len(txs)
+		}
 		flag := false
 		select {
 		case <-eventTimeout:
 			flag = true
 		default:
-			txs := chain.Pool.Pop(20)
-			if len(txs) > 0 {
-				if !started {
-					started = true
-					start = time.Now().UnixNano()
-				}
-				for _, tx := range txs {
-					block.NewTransaction(*tx)
-				}
-				numTx += len(txs)
-			}
 		}
 		if flag {
 			break
 		}
 	}
 
-	end := time.Now().UnixNano()
-	log.Debug("Total tx: %d, Total time: %d ns, TPS: %d. \n", numTx, end-start, numTx*1e9/int(end-start))
+	end := chain.Clock.Now().UnixNano()
+	if end > start {
+		log.Debug("Total tx: %d, Total time: %d ns, TPS: %d. \n", numTx, end-start, numTx*1e9/int(end-start))
+	}
 }
 
 // 当区块写入区块时，notify交易池，一些nonce比较大的交易可以进行打包
@@ -96,6 +107,17 @@ func (chain *BlockChain) NotifyPool(txs []userevent.Transaction) {
 	chain.Pool.Notify(txs)
 }
 
+// 回滚之后把孤块中的交易重新放回交易池,已经在当前链中执行过的交易会被忽略,返回放回的数量
+func (chain *BlockChain) Requeue(txs []userevent.Transaction) int {
+	count := 0
+	for i := range txs {
+		if chain.NewTransaction(&txs[i]) {
+			count++
+		}
+	}
+	return count
+}
+
 func (chain *BlockChain) NewTransaction(tx *userevent.Transaction) bool {
 	block := chain.LastHeader()
 	account, err := block.GetAccount(tx.GetFrom())
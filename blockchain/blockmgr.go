@@ -3,7 +3,6 @@ package blockchain
 import (
 	"encoding/hex"
 	"sync"
-	"time"
 )
 
 const (
@@ -21,10 +20,15 @@ const (
 	BLOCK_ERROR_HASH           = 303
 	BLOCK_ERROR_SIGN           = 304
 	BLOCK_ERROR_BODY           = 305
+	BLOCK_ERROR_MINER          = 306
+	BLOCK_ERROR_PREVIOUS_HASH  = 307
 	BLOCK_ERROR_END            = 399
 
 	// 400已经写入区块链
 	BLOCK_SAVED = 400
+
+	// 500子区块也已经有超过2/3委托人的commit投票，不可逆
+	BLOCK_FINALIZED = 500
 )
 
 // 内部操作不加lock，外部在需要加锁的地方加锁，保证操作的原子性
@@ -33,6 +37,8 @@ type BlockManager struct {
 	BlockStatus   *sync.Map // 根据区块hash计算，主要是从peer来的区块 100：待处理 	101：已经处理成功，未写入区块 	400：错误的区块头 		200：处理成功，已经写入区块
 	HeightManager *sync.Map // 根据block的height进行计算，主要是防止内部多次进行打包 100代表未打包，101代表已打包
 	HeightVote    *sync.Map //上次在某个高度的投票时间，防止重复投票
+	PackedBlocks  *sync.Map // 本节点在某个高度签名的区块，写入这个高度之后删除
+	SignedHeights *sync.Map // 本节点签名过区块的高度，不可逆之后才删除，回滚之后同一个高度也不会签名第二个区块
 }
 
 func NewBlockManager() *BlockManager {
@@ -41,6 +47,8 @@ func NewBlockManager() *BlockManager {
 		BlockStatus:   &sync.Map{},
 		HeightManager: &sync.Map{},
 		HeightVote:    &sync.Map{},
+		PackedBlocks:  &sync.Map{},
+		SignedHeights: &sync.Map{},
 	}
 }
 
@@ -73,13 +81,13 @@ func (manager *BlockManager) SetVoteTime(height int64, time int64) {
 }
 
 // 根据区块高度判断自己是否可以对此高度进行打包
-// 一个区块在1个interval内不可以对同一个高度的区块进行打包
-func (manager *BlockManager) CheckHeightInterval(height, interval int64) bool {
+// 一个区块在1个interval内不可以对同一个高度的区块进行打包,now是当前的毫秒时间戳
+func (manager *BlockManager) CheckHeightInterval(height, interval, now int64) bool {
 	t, exist := manager.HeightManager.Load(height)
 	if !exist {
 		return true
 	}
-	return t.(int64)+interval/1e6 < time.Now().UnixNano()/1e6
+	return t.(int64)+interval/1e6 < now
 }
 
 func (manager *BlockManager) SetBlockStatusByHeight(height, ms int64) {
@@ -97,6 +105,63 @@ func (manager *BlockManager) Insert(block *Block) {
 	}
 }
 
+func (manager *BlockManager) GetPackedBlock(height int64) *Block {
+	b, exist := manager.PackedBlocks.Load(height)
+	if !exist {
+		return nil
+	}
+	return b.(*Block)
+}
+
+func (manager *BlockManager) SetPackedBlock(height int64, block *Block) {
+	manager.PackedBlocks.Store(height, block)
+	manager.SignedHeights.Store(height, block.Hash)
+}
+
+// 本节点是否在height签名过区块
+func (manager *BlockManager) IsSigned(height int64) bool {
+	_, exist := manager.SignedHeights.Load(height)
+	return exist
+}
+
+// 区块写入之后删除这个高度及之前签名的区块,不再需要重新广播
+func (manager *BlockManager) ClearPackedBlocks(height int64) {
+	clearHeights(manager.PackedBlocks, height)
+}
+
+// 高度不可逆之后删除这个高度及之前的签名记录,这些高度不会再回滚
+func (manager *BlockManager) ClearSignedHeights(height int64) {
+	clearHeights(manager.SignedHeights, height)
+}
+
+func clearHeights(m *sync.Map, height int64) {
+	m.Range(func(key, value interface{}) bool {
+		if key.(int64) <= height {
+			m.Delete(key)
+		}
+		return true
+	})
+}
+
+// 回滚之后删除被丢弃的区块和它的状态,重新收到这个区块时重新校验
+func (manager *BlockManager) Remove(hash []byte) {
+	manager.Blocks.Delete(hex.EncodeToString(hash))
+	manager.BlockStatus.Delete(hex.EncodeToString(hash))
+}
+
+// 回滚之后删除height之后每个高度的打包时间和投票记录
+// 本节点的签名记录不删除,回滚之后同一个高度仍然只会签名一个区块
+func (manager *BlockManager) Reset(height int64) {
+	for _, m := range []*sync.Map{manager.HeightManager, manager.HeightVote} {
+		m.Range(func(key, value interface{}) bool {
+			if key.(int64) > height {
+				m.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
 func (manager *BlockManager) GetBlock(hash []byte) *Block {
 	b, exist := manager.Blocks.Load(hex.EncodeToString(hash))
 	if !exist {
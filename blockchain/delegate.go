@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/param"
+	"github.com/OpenOCC/OCC/util"
+)
+
+const (
+	DELEGATE_EPOCH        = 1200 // 委托人集合在高度为DELEGATE_EPOCH整数倍的区块中更新,下一个区块开始生效
+	MAX_DELEGATES         = 21
+	DELEGATE_ADDR_VERSION = 4
+)
+
+// DelegateTree中保存当前委托人集合的key,和账户地址一样是32字节,不会和候选人的key冲突
+var activeDelegatesKey = crypto.Sha3_256([]byte("ActiveDelegates"))
+
+// 委托人候选,保存在DelegateTree中,key是候选人的账户地址
+type Candidate struct {
+	Peer   types.Peer `json:"peer"`
+	Height int64      `json:"height"` // 注册的区块高度
+}
+
+func IsEpochHeight(height int64) bool {
+	return height > 0 && height%DELEGATE_EPOCH == 0
+}
+
+// 注册交易的Data是节点的地址,格式为host:port
+func parseDelegatePeer(tx userevent.Transaction) (*types.Peer, error) {
+	host, port, err := net.SplitHostPort(tx.Data)
+	if err != nil {
+		return nil, err
+	}
+	_port, err := strconv.ParseInt(port, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Peer{
+		Account:        hex.EncodeToString(tx.From),
+		Address:        host,
+		Port:           int32(_port),
+		AddressVersion: DELEGATE_ADDR_VERSION,
+	}, nil
+}
+
+// 执行委托人注册和取消注册的交易,交易只修改候选人列表,委托人集合在epoch高度更新
+func (header *Header) delegateTransaction(tx userevent.Transaction, account *types.Account) userevent.TransactionReceipt {
+	if header.DelegateTree == nil {
+		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+	}
+	switch tx.Type {
+	case userevent.TX_TYPE_REGISTER_DELEGATE:
+		peer, err := parseDelegatePeer(tx)
+		if err != nil {
+			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+		}
+		candidate := Candidate{Peer: *peer, Height: header.Height}
+		data, _ := json.Marshal(candidate)
+		if header.DelegateTree.MustInsert(tx.GetFrom(), data) != nil {
+			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+		}
+	case userevent.TX_TYPE_UNREGISTER_DELEGATE:
+		if header.DelegateTree.Delete(tx.GetFrom()) != nil {
+			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+		}
+	default:
+		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+	}
+	account.BurnGas(tx.Fee)
+	account.Nonce++
+	header.StatTree.MustInsert(tx.GetFrom(), account.ToBytes())
+	return userevent.NewTransactionReceipt(tx, true, userevent.FailType_SUCCESS)
+}
+
+// 返回DelegateTree中所有的候选人,按照账户地址排序
+func (header Header) GetCandidates() ([]Candidate, error) {
+	candidates := make([]Candidate, 0)
+	if header.DelegateTree == nil {
+		return candidates, nil
+	}
+	err := header.DelegateTree.Iterate(nil, nil, func(key, value []byte) bool {
+		if bytes.Equal(key, activeDelegatesKey) {
+			return true
+		}
+		var candidate Candidate
+		if json.Unmarshal(value, &candidate) == nil {
+			candidates = append(candidates, candidate)
+		}
+		return true
+	})
+	return candidates, err
+}
+
+// 返回链上记录的当前委托人集合,没有记录时返回nil
+func (header Header) GetDelegates() types.Peers {
+	if header.DelegateTree == nil || !header.DelegateTree.ContainsKey(activeDelegatesKey) {
+		return nil
+	}
+	data, err := header.DelegateTree.GetValue(activeDelegatesKey)
+	if err != nil {
+		return nil
+	}
+	var peers types.Peers
+	if json.Unmarshal(data, &peers) != nil {
+		return nil
+	}
+	return peers
+}
+
+// 当前生效的委托人集合,链上没有记录时是启动时配置的委托人
+func (header Header) currentDelegates() types.Peers {
+	if peers := header.GetDelegates(); len(peers) > 0 {
+		return peers
+	}
+	return param.MainChainDelegateNode
+}
+
+// 在epoch高度根据候选人重新计算委托人集合
+// 只有得票大于0的候选人可以当选,当选人数不足当前委托人集合的2/3时保持原有的委托人集合
+// 按照得票从高到低选出前MAX_DELEGATES个候选人,得票相同时先注册的优先
+func (header *Header) UpdateDelegates() error {
+	if header.DelegateTree == nil || !IsEpochHeight(header.Height) {
+		return nil
+	}
+	all, err := header.GetCandidates()
+	if err != nil {
+		return err
+	}
+	tallies := make(map[string]int64)
+	candidates := make([]Candidate, 0, len(all))
+	for _, candidate := range all {
+		address, _ := hex.DecodeString(candidate.Peer.Account)
+		if tally := header.GetTally(address); tally > 0 {
+			tallies[candidate.Peer.Account] = tally
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 || len(candidates) < util.MoreThanTwoThirds(len(header.currentDelegates())) {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		tally1, tally2 := tallies[candidates[i].Peer.Account], tallies[candidates[j].Peer.Account]
+		if tally1 != tally2 {
+			return tally1 > tally2
+		}
+		return candidates[i].Height < candidates[j].Height
+	})
+	if len(candidates) > MAX_DELEGATES {
+		candidates = candidates[:MAX_DELEGATES]
+	}
+	peers := make(types.Peers, 0, len(candidates))
+	for _, candidate := range candidates {
+		peers = append(peers, candidate.Peer)
+	}
+	data, _ := json.Marshal(peers)
+	return header.DelegateTree.MustInsert(activeDelegatesKey, data)
+}
@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+	"github.com/OpenOCC/OCC/param"
+)
+
+func TestDelegateRegistration(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	enableAllVersions()
+	defer SetVersionHeights(nil)
+
+	accounts := make([]types.Account, 0)
+	for i := 0; i < 3; i++ {
+		address := crypto.Sha3_256([]byte{byte(i)})
+		account := types.CreateAccount(address, 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+	}
+	bootstrap := types.Peers{{Account: "bootstrap", Address: "127.0.0.1", Port: 19950}}
+	param.MainChainDelegateNode = bootstrap
+	defer func() { param.MainChainDelegateNode = nil }()
+	genesis := GenesisHeader(accounts)
+	last := *genesis
+	last.Height = DELEGATE_EPOCH - 1
+
+	header := NewHeader(last, last.CaculateHash(), accounts[0].Address)
+	register := func(i int, txType int, data string) userevent.TransactionReceipt {
+		tx := userevent.NewTransaction(accounts[i].Address, accounts[i].Address, 0, 0, 1, 1, data, "")
+		tx.Type = txType
+		return header.NewTransaction(*tx)
+	}
+	if register(0, userevent.TX_TYPE_REGISTER_DELEGATE, "127.0.0.1:19951").FailType != userevent.FailType_SUCCESS {
+		t.Fatal("register should succeed")
+	}
+	if register(1, userevent.TX_TYPE_REGISTER_DELEGATE, "invalid").FailType != userevent.FailType_INVALID_DELEGATE {
+		t.Fatal("register with invalid address should fail")
+	}
+	if register(2, userevent.TX_TYPE_UNREGISTER_DELEGATE, "").FailType != userevent.FailType_INVALID_DELEGATE {
+		t.Fatal("unregister without registration should fail")
+	}
+	if header.GetDelegates() != nil {
+		t.Fatal("delegates should not change before epoch")
+	}
+	if err := header.UpdateDelegates(); err != nil {
+		t.Fatal(err)
+	}
+	// 候选人没有得票,启动时配置的委托人继续生效
+	if delegates := header.GetDelegates(); delegates != nil {
+		t.Fatalf("candidate without votes should not replace bootstrap delegates, got %v", delegates)
+	}
+	if candidates, _ := header.GetCandidates(); len(candidates) != 1 || candidates[0].Peer.Account != hex.EncodeToString(accounts[0].Address) || candidates[0].Peer.Port != 19951 {
+		t.Fatalf("unexpected candidates %v", candidates)
+	}
+	if account, _ := header.GetAccount(accounts[0].Address); account.Nonce != 1 || account.Gas != 99 {
+		t.Fatalf("register should burn gas and increase nonce, %v", account)
+	}
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+)
+
+// 返回候选人在VoteTree中的得票,没有得票时返回0
+func (header Header) GetTally(candidate []byte) int64 {
+	if header.VoteTree == nil || !header.VoteTree.ContainsKey(candidate) {
+		return 0
+	}
+	data, err := header.VoteTree.GetValue(candidate)
+	if err != nil {
+		return 0
+	}
+	tally, _ := strconv.ParseInt(string(data), 10, 64)
+	return tally
+}
+
+// 得票为0时从VoteTree中删除,保证没有得票的候选人不影响VoteTree的root
+func (header *Header) setTally(candidate []byte, tally int64) error {
+	if tally == 0 {
+		if header.VoteTree.ContainsKey(candidate) {
+			return header.VoteTree.Delete(candidate)
+		}
+		return nil
+	}
+	return header.VoteTree.MustInsert(candidate, []byte(strconv.FormatInt(tally, 10)))
+}
+
+func (header Header) IsCandidate(address []byte) bool {
+	return header.DelegateTree != nil && !bytes.Equal(address, activeDelegatesKey) &&
+		header.DelegateTree.ContainsKey(address)
+}
+
+// 执行投票和撤回投票的交易,投票只能投给已经注册的候选人,撤回投票不要求候选人仍然在注册中
+func (header *Header) voteTransaction(tx userevent.Transaction, account *types.Account) userevent.TransactionReceipt {
+	if header.VoteTree == nil {
+		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+	}
+	candidate := hex.EncodeToString(tx.GetTo())
+	tally := header.GetTally(tx.GetTo())
+	switch tx.Type {
+	case userevent.TX_TYPE_VOTE:
+		if !header.IsCandidate(tx.GetTo()) || !account.Vote(candidate, tx.Amount) {
+			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+		}
+		tally += tx.Amount
+	case userevent.TX_TYPE_UNVOTE:
+		if !account.Unvote(candidate, tx.Amount) {
+			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+		}
+		tally -= tx.Amount
+	default:
+		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+	}
+	if header.setTally(tx.GetTo(), tally) != nil {
+		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_INVALID_DELEGATE)
+	}
+	account.BurnGas(tx.Fee)
+	account.Nonce++
+	header.StatTree.MustInsert(tx.GetFrom(), account.ToBytes())
+	return userevent.NewTransactionReceipt(tx, true, userevent.FailType_SUCCESS)
+}
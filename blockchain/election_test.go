@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+	"github.com/OpenOCC/OCC/param"
+)
+
+func TestDelegateElection(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	enableAllVersions()
+	defer SetVersionHeights(nil)
+
+	accounts := make([]types.Account, 0)
+	for i := 0; i < 4; i++ {
+		account := types.CreateAccount(crypto.Sha3_256([]byte{byte(i)}), 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+	}
+	// 启动时配置4个委托人,至少3个候选人得票才会替换委托人集合
+	param.MainChainDelegateNode = make(types.Peers, 4)
+	defer func() { param.MainChainDelegateNode = nil }()
+	last := *GenesisHeader(accounts)
+	last.Height = DELEGATE_EPOCH - 1
+	header := NewHeader(last, last.CaculateHash(), accounts[0].Address)
+
+	nonces := make(map[int]int64)
+	send := func(from, to int, txType int, amount int64, data string) int {
+		nonces[from]++
+		tx := userevent.NewTransaction(accounts[from].Address, accounts[to].Address, 0, amount, 1, nonces[from], data, "")
+		tx.Type = txType
+		receipt := header.NewTransaction(*tx)
+		if !receipt.Success {
+			nonces[from]--
+		}
+		return receipt.FailType
+	}
+	for i := 0; i < 3; i++ {
+		if send(i, i, userevent.TX_TYPE_REGISTER_DELEGATE, 0, "127.0.0.1:1995"+string(rune('1'+i))) != userevent.FailType_SUCCESS {
+			t.Fatal("register should succeed")
+		}
+	}
+	if send(3, 3, userevent.TX_TYPE_VOTE, 10, "") != userevent.FailType_INVALID_DELEGATE {
+		t.Fatal("vote for non-candidate should fail")
+	}
+	if send(3, 2, userevent.TX_TYPE_VOTE, 200, "") != userevent.FailType_INVALID_DELEGATE {
+		t.Fatal("vote more than amount should fail")
+	}
+	send(3, 2, userevent.TX_TYPE_VOTE, 60, "")
+	send(0, 1, userevent.TX_TYPE_VOTE, 50, "")
+	send(3, 2, userevent.TX_TYPE_UNVOTE, 20, "")
+	if header.GetTally(accounts[2].Address) != 40 || header.GetTally(accounts[1].Address) != 50 {
+		t.Fatal("unexpected tally")
+	}
+	if send(0, 1, userevent.TX_TYPE_UNVOTE, 60, "") != userevent.FailType_INVALID_DELEGATE {
+		t.Fatal("unvote more than locked should fail")
+	}
+	if account, _ := header.GetAccount(accounts[3].Address); account.Amount != 60 || account.Votes[hex.EncodeToString(accounts[2].Address)] != 40 {
+		t.Fatalf("unexpected voter account %v", account)
+	}
+
+	if err := header.UpdateDelegates(); err != nil {
+		t.Fatal(err)
+	}
+	if delegates := header.GetDelegates(); delegates != nil {
+		t.Fatalf("two voted candidates should not replace four delegates, got %v", delegates)
+	}
+	send(3, 0, userevent.TX_TYPE_VOTE, 10, "")
+	if err := header.UpdateDelegates(); err != nil {
+		t.Fatal(err)
+	}
+	delegates := header.GetDelegates()
+	expect := []int{1, 2, 0}
+	if len(delegates) != len(expect) {
+		t.Fatalf("unexpected delegates %v", delegates)
+	}
+	for i, index := range expect {
+		if delegates[i].Account != hex.EncodeToString(accounts[index].Address) {
+			t.Fatalf("unexpected delegates %v", delegates)
+		}
+	}
+}
+
+func TestEvidencePenalty(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	enableAllVersions()
+	defer SetVersionHeights(nil)
+
+	accounts := make([]types.Account, 0)
+	for i := 0; i < 2; i++ {
+		account := types.CreateAccount(crypto.Sha3_256([]byte{byte(i)}), 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+	}
+	last := *GenesisHeader(accounts)
+	header := NewHeader(last, last.CaculateHash(), accounts[0].Address)
+	send := func(from, to int, txType int, amount, nonce int64, data string) {
+		tx := userevent.NewTransaction(accounts[from].Address, accounts[to].Address, 0, amount, 1, nonce, data, "")
+		tx.Type = txType
+		if !header.NewTransaction(*tx).Success {
+			t.Fatalf("transaction %d should succeed", txType)
+		}
+	}
+	send(0, 0, userevent.TX_TYPE_REGISTER_DELEGATE, 0, 1, "127.0.0.1:19951")
+	send(0, 0, userevent.TX_TYPE_VOTE, 50, 2, "")
+	send(1, 0, userevent.TX_TYPE_VOTE, 30, 1, "")
+
+	// 作恶委托人投给自己的余额同样被扣除,其他人的投票不受影响
+	evidence := Evidence{Type: EVIDENCE_TYPE_BLOCK, Offender: types.Peer{Account: hex.EncodeToString(accounts[0].Address)}}
+	if err := header.ApplyEvidence(evidence); err != nil {
+		t.Fatal(err)
+	}
+	account, _ := header.GetAccount(accounts[0].Address)
+	if account.Amount != 45 || account.Votes[hex.EncodeToString(accounts[0].Address)] != 45 {
+		t.Fatalf("penalty should cover amount and voted stake, %v", account)
+	}
+	if tally := header.GetTally(accounts[0].Address); tally != 75 {
+		t.Fatalf("penalty should reduce the tally of voted candidate, got %d", tally)
+	}
+}
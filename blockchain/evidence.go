@@ -0,0 +1,257 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+const (
+	EVIDENCE_TYPE_BLOCK = 1 // 同一个高度签名了两个不同的区块
+	EVIDENCE_TYPE_VOTE  = 2 // 同一个高度对两个不同的区块发送了commit投票
+
+	EVIDENCE_PENALTY_PERCENT = 10 // 作恶的委托人被扣除的余额百分比
+	MAX_EVIDENCES_PER_BLOCK  = 8
+)
+
+// 签名的区块头,Signature是Miner对区块hash的签名
+type SignedHeader struct {
+	Header    *Header        `json:"header"`
+	Signature types.HexBytes `json:"signature"`
+}
+
+/*
+*委托人作恶的证据,包含同一个委托人在同一个高度签名的两个互相冲突的消息
+*任何节点都可以只根据证据本身校验,不需要访问数据库
+*Offender只包含小写的账户,节点地址和端口没有签名,不能出现在证据中
+ */
+type Evidence struct {
+	Type     int            `json:"type"`
+	Offender types.Peer     `json:"offender"`
+	Height   int64          `json:"height"`
+	Headers  []SignedHeader `json:"headers,omitempty"`
+	Votes    Votes          `json:"votes,omitempty"`
+}
+
+type Evidences []Evidence
+
+func NewBlockEvidence(offender types.Peer, header1, header2 SignedHeader) Evidence {
+	headers := []SignedHeader{header1, header2}
+	sort.Slice(headers, func(i, j int) bool {
+		return bytes.Compare(headers[i].Header.CaculateHash(), headers[j].Header.CaculateHash()) < 0
+	})
+	return Evidence{
+		Type:     EVIDENCE_TYPE_BLOCK,
+		Offender: offenderOf(offender.Account),
+		Height:   header1.Header.Height,
+		Headers:  headers,
+	}
+}
+
+func NewVoteEvidence(vote1, vote2 PeerBlockVote) Evidence {
+	votes := Votes{vote1, vote2}
+	sort.Slice(votes, func(i, j int) bool {
+		return bytes.Compare(votes[i].Vote.BlockHash, votes[j].Vote.BlockHash) < 0
+	})
+	return Evidence{
+		Type:     EVIDENCE_TYPE_VOTE,
+		Offender: offenderOf(vote1.Peer.Account),
+		Height:   vote1.Vote.BlockHeight,
+		Votes:    votes,
+	}
+}
+
+func offenderOf(account string) types.Peer {
+	return types.Peer{Account: strings.ToLower(account)}
+}
+
+// 同一个委托人在同一个高度的同一类作恶只处罚一次,保存和打包都按照Key去重
+// 同一个高度的第三个冲突消息或者修改了没有签名的字段的证据Key都相同
+func (evidence Evidence) Key() string {
+	return fmt.Sprintf("%d_%s_%d", evidence.Type, strings.ToLower(evidence.Offender.Account), evidence.Height)
+}
+
+func (evidence Evidence) Bytes() []byte {
+	data, _ := json.Marshal(evidence)
+	return data
+}
+
+func (evidence Evidence) Hash() []byte {
+	return crypto.Sha3_256(evidence.Bytes())
+}
+
+func (evidences Evidences) Bytes() []byte {
+	data, _ := json.Marshal(evidences)
+	return data
+}
+
+func (evidences Evidences) Hash() []byte {
+	return crypto.Sha3_256(evidences.Bytes())
+}
+
+func (header SignedHeader) Validate(account string) bool {
+	if header.Header == nil {
+		return false
+	}
+	pubKey, err := crypto.RecoverPubKey(header.Header.CaculateHash(), header.Signature)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(hex.EncodeToString(types.FromPubKeyToAddress(pubKey)), account)
+}
+
+// 校验证据中的两个消息是否都由Offender签名,并且在同一个高度互相冲突
+func (evidence Evidence) Validate() bool {
+	if evidence.Offender != offenderOf(evidence.Offender.Account) {
+		return false
+	}
+	switch evidence.Type {
+	case EVIDENCE_TYPE_BLOCK:
+		if len(evidence.Headers) != 2 || len(evidence.Votes) != 0 {
+			return false
+		}
+		header1, header2 := evidence.Headers[0], evidence.Headers[1]
+		if !header1.Validate(evidence.Offender.Account) || !header2.Validate(evidence.Offender.Account) {
+			return false
+		}
+		return header1.Header.Height == evidence.Height && header2.Header.Height == evidence.Height &&
+			!bytes.Equal(header1.Header.CaculateHash(), header2.Header.CaculateHash())
+	case EVIDENCE_TYPE_VOTE:
+		if len(evidence.Votes) != 2 || len(evidence.Headers) != 0 {
+			return false
+		}
+		vote1, vote2 := evidence.Votes[0], evidence.Votes[1]
+		if !vote1.Validate() || !vote2.Validate() {
+			return false
+		}
+		if !strings.EqualFold(vote1.Peer.Account, evidence.Offender.Account) ||
+			!strings.EqualFold(vote2.Peer.Account, evidence.Offender.Account) {
+			return false
+		}
+		return vote1.Vote.Phase == VOTE_PHASE_COMMIT && vote2.Vote.Phase == VOTE_PHASE_COMMIT &&
+			vote1.Vote.BlockchainId == vote2.Vote.BlockchainId && vote1.Vote.View == vote2.Vote.View &&
+			vote1.Vote.BlockHeight == evidence.Height && vote2.Vote.BlockHeight == evidence.Height &&
+			!bytes.Equal(vote1.Vote.BlockHash, vote2.Vote.BlockHash)
+	}
+	return false
+}
+
+/*
+*EquivocationDetector记录每个委托人在每个高度第一次签名的区块和在每个view第一次签名的commit投票
+*同一个委托人在同一个高度再签名不同的区块,或者在同一个view再签名不同的commit投票时生成证据
+*prepare投票在view change之后可以对同一个高度的新区块重新发送,所以不作为作恶的依据
+*更高view的prepare证书可以解除锁定,所以不同view对不同区块的commit投票也不是作恶
+ */
+type EquivocationDetector struct {
+	headers *sync.Map
+	votes   *sync.Map
+}
+
+func NewEquivocationDetector() EquivocationDetector {
+	return EquivocationDetector{
+		headers: &sync.Map{},
+		votes:   &sync.Map{},
+	}
+}
+
+func detectorKey(account string, height int64) string {
+	return fmt.Sprintf("%s_%d", strings.ToLower(account), height)
+}
+
+// 检查miner签名的区块头,发现冲突时返回证据,签名错误的区块头直接忽略
+func (detector EquivocationDetector) CheckBlock(miner types.Peer, header SignedHeader) *Evidence {
+	if !header.Validate(miner.Account) {
+		return nil
+	}
+	obj, loaded := detector.headers.LoadOrStore(detectorKey(miner.Account, header.Header.Height), header)
+	if !loaded {
+		return nil
+	}
+	first := obj.(SignedHeader)
+	if bytes.Equal(first.Header.CaculateHash(), header.Header.CaculateHash()) {
+		return nil
+	}
+	evidence := NewBlockEvidence(miner, first, header)
+	return &evidence
+}
+
+// 检查委托人的commit投票,发现冲突时返回证据
+func (detector EquivocationDetector) CheckVote(vote PeerBlockVote) *Evidence {
+	if vote.Vote.Phase != VOTE_PHASE_COMMIT || !vote.Validate() {
+		return nil
+	}
+	obj, loaded := detector.votes.LoadOrStore(fmt.Sprintf("%s_%d", detectorKey(vote.Peer.Account, vote.Vote.BlockHeight), vote.Vote.View), vote)
+	if !loaded {
+		return nil
+	}
+	first := obj.(PeerBlockVote)
+	if bytes.Equal(first.Vote.BlockHash, vote.Vote.BlockHash) {
+		return nil
+	}
+	evidence := NewVoteEvidence(first, vote)
+	return &evidence
+}
+
+// 区块写入之后清理这个高度之前的记录
+func (detector EquivocationDetector) Clear(height int64) {
+	clear := func(key, value interface{}) bool {
+		var h int64
+		switch v := value.(type) {
+		case SignedHeader:
+			h = v.Header.Height
+		case PeerBlockVote:
+			h = v.Vote.BlockHeight
+		}
+		if h < height {
+			detector.headers.Delete(key)
+			detector.votes.Delete(key)
+		}
+		return true
+	}
+	detector.headers.Range(clear)
+	detector.votes.Range(clear)
+}
+
+// 扣除作恶委托人的余额和投票锁定的余额,证据在区块中按照顺序执行
+// 投票锁定的部分同时从候选人的得票中扣除,避免作恶委托人通过投票转移余额逃避惩罚
+func (header *Header) ApplyEvidence(evidence Evidence) error {
+	address, err := hex.DecodeString(evidence.Offender.Account)
+	if err != nil {
+		return err
+	}
+	account, err := header.GetAccount(address)
+	if account == nil || err != nil {
+		account = types.NewAccount(address)
+	}
+	account.Amount -= account.Amount * EVIDENCE_PENALTY_PERCENT / 100
+	candidates := make([]string, 0, len(account.Votes))
+	for candidate := range account.Votes {
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	for _, candidate := range candidates {
+		penalty := account.Votes[candidate] * EVIDENCE_PENALTY_PERCENT / 100
+		if penalty == 0 {
+			continue
+		}
+		account.Votes[candidate] -= penalty
+		if account.Votes[candidate] == 0 {
+			delete(account.Votes, candidate)
+		}
+		if header.VoteTree == nil {
+			continue
+		}
+		_candidate, _ := hex.DecodeString(candidate)
+		if err := header.setTally(_candidate, header.GetTally(_candidate)-penalty); err != nil {
+			return err
+		}
+	}
+	return header.StatTree.MustInsert(address, account.ToBytes())
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+func TestEquivocationDetector(t *testing.T) {
+	peers, privKeys := newVoters(2)
+	detector := NewEquivocationDetector()
+
+	// commit投票冲突
+	vote1 := signVotes(t, peers[:1], privKeys[:1], VOTE_PHASE_COMMIT)[0]
+	vote2 := vote1
+	vote2.Vote.BlockHash = crypto.Sha3_256([]byte("another block"))
+	if err := vote2.Sign(privKeys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if detector.CheckVote(vote1) != nil || detector.CheckVote(vote1) != nil {
+		t.Fatal("same vote should not be evidence")
+	}
+	evidence := detector.CheckVote(vote2)
+	if evidence == nil || !evidence.Validate() {
+		t.Fatal("conflicting commit votes should be valid evidence")
+	}
+	// 没有签名的节点地址和账户大小写不能生成新的证据
+	key := evidence.Key()
+	altered := *evidence
+	altered.Offender.Port = 1
+	if altered.Validate() {
+		t.Fatal("evidence with offender address should be invalid")
+	}
+	altered.Offender = types.Peer{Account: strings.ToUpper(peers[0].Account)}
+	if altered.Validate() || altered.Key() != key {
+		t.Fatal("evidence with upper case offender should be invalid and have the same key")
+	}
+	vote4 := vote1
+	vote4.Vote.BlockHash = crypto.Sha3_256([]byte("third block"))
+	if err := vote4.Sign(privKeys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if third := detector.CheckVote(vote4); third == nil || !third.Validate() || third.Key() != key {
+		t.Fatal("third conflicting vote at the same height should have the same key")
+	}
+	if evidence.Votes[1].Signature = vote1.Signature; evidence.Validate() {
+		t.Fatal("evidence with wrong signature should be invalid")
+	}
+
+	// 更高view的prepare证书解除锁定之后可以commit另一个区块
+	vote3 := vote1
+	vote3.Vote.BlockHash, vote3.Vote.View = crypto.Sha3_256([]byte("next view")), 1
+	if err := vote3.Sign(privKeys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if detector.CheckVote(vote3) != nil {
+		t.Fatal("commit votes in different views should not be evidence")
+	}
+	if evidence := NewVoteEvidence(vote1, vote3); evidence.Validate() {
+		t.Fatal("evidence with votes in different views should be invalid")
+	}
+
+	// prepare投票在view change之后可以重新发送
+	prepare1 := signVotes(t, peers[1:], privKeys[1:], VOTE_PHASE_PREPARE)[0]
+	prepare2 := prepare1
+	prepare2.Vote.BlockHash = vote2.Vote.BlockHash
+	prepare2.Sign(privKeys[1])
+	if detector.CheckVote(prepare1) != nil || detector.CheckVote(prepare2) != nil {
+		t.Fatal("prepare votes should not be evidence")
+	}
+
+	// 区块冲突
+	header1 := &Header{Height: 1, Timestamp: 1}
+	header2 := &Header{Height: 1, Timestamp: 2}
+	sign1, _ := crypto.Crypto(header1.CaculateHash(), privKeys[1])
+	sign2, _ := crypto.Crypto(header2.CaculateHash(), privKeys[1])
+	if detector.CheckBlock(peers[1], SignedHeader{Header: header1, Signature: sign1}) != nil {
+		t.Fatal("first block should not be evidence")
+	}
+	if detector.CheckBlock(peers[0], SignedHeader{Header: header2, Signature: sign2}) != nil {
+		t.Fatal("block signed by another peer should be ignored")
+	}
+	evidence = detector.CheckBlock(peers[1], SignedHeader{Header: header2, Signature: sign2})
+	if evidence == nil || !evidence.Validate() || evidence.Type != EVIDENCE_TYPE_BLOCK {
+		t.Fatal("conflicting blocks should be valid evidence")
+	}
+}
@@ -3,6 +3,8 @@ package blockchain
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"math"
 	"time"
 
 	"github.com/OpenOCC/OCC/MPTPlus"
@@ -14,10 +16,69 @@ import (
 )
 
 const (
-	HEADER_VERSION_PURE_MTP = 0
-	HEADER_VERSION_MIXED    = 1
+	HEADER_VERSION_PURE_MTP  = 0
+	HEADER_VERSION_MIXED     = 1
+	HEADER_VERSION_RLP_MTP   = 2 // 从此版本开始StatTree和TokenTree的新节点使用RLP编码
+	HEADER_VERSION_DELEGATE  = 3 // 从此版本开始委托人集合保存在DelegateTree中
+	HEADER_VERSION_DPOS      = 4 // 从此版本开始候选人的得票保存在VoteTree中,按照得票选出委托人
+	HEADER_VERSION_TX_MERKLE = 5 // 从此版本开始TxHash是交易id和回执组成的二叉默克尔树的根
+
+	HEADER_VERSION_LATEST = HEADER_VERSION_TX_MERKLE
 )
 
+var InvalidVersionHeightsError = errors.New("version heights must start at 0 and be non-decreasing")
+
+/*
+*versionHeights[i]是区块头版本i的启用高度,高度为height的区块使用启用高度不大于height的最高版本
+*升级时先发布新版本的代码,再通过配置在未来的高度启用,避免新旧版本的委托人在升级时分叉
+*默认只启用HEADER_VERSION_MIXED,和已有的链一致,新版本需要配置启用高度之后才生效
+ */
+var versionHeights = defaultVersionHeights()
+
+func defaultVersionHeights() []int64 {
+	heights := make([]int64, HEADER_VERSION_LATEST+1)
+	for version := HEADER_VERSION_MIXED + 1; version < len(heights); version++ {
+		heights[version] = math.MaxInt64
+	}
+	return heights
+}
+
+// 设置每个版本的启用高度,版本0必须从高度0启用,高度必须随版本递增
+// heights为空时恢复默认的启用高度,否则heights之后的版本不启用
+func SetVersionHeights(heights []int64) error {
+	if len(heights) == 0 {
+		versionHeights = defaultVersionHeights()
+		return nil
+	}
+	if len(heights) > HEADER_VERSION_LATEST+1 || heights[0] != 0 {
+		return InvalidVersionHeightsError
+	}
+	_heights := make([]int64, HEADER_VERSION_LATEST+1)
+	for version := range _heights {
+		if version < len(heights) {
+			_heights[version] = heights[version]
+		} else {
+			_heights[version] = math.MaxInt64
+		}
+		if version > 0 && _heights[version] < _heights[version-1] {
+			return InvalidVersionHeightsError
+		}
+	}
+	versionHeights = _heights
+	return nil
+}
+
+// 高度为height的区块头应该使用的版本
+func HeaderVersion(height int64) int {
+	version := 0
+	for v, activation := range versionHeights {
+		if activation <= height {
+			version = v
+		}
+	}
+	return version
+}
+
 type Header struct {
 	Height       int64          `json:"height"`
 	Timestamp    int64          `json:"timestamp"`
@@ -29,6 +90,9 @@ type Header struct {
 	TxHash       types.HexBytes `json:"txHash"`
 	ReceiptHash  types.HexBytes `json:"receiptHash"`
 	Version      int            `json:"version"`
+	EvidenceHash types.HexBytes `json:"evidenceHash,omitempty"` // 区块中包含的作恶证据的hash,没有证据时为空
+	DelegateTree *MPTPlus.MTP   `json:"delegateRoot,omitempty"` // 委托人候选和当前委托人集合,HEADER_VERSION_DELEGATE之前为空
+	VoteTree     *MPTPlus.MTP   `json:"voteRoot,omitempty"`     // 候选人的得票,HEADER_VERSION_DPOS之前为空
 }
 
 func (header *Header) Bytes() []byte {
@@ -84,12 +148,66 @@ func NewHeader(last Header, parentHash types.HexBytes, coinbase types.HexBytes)
 		Coinbase:     coinbase,
 		StatTree:     MPTPlus.MTP_Tree(db.GetDBInst(), last.StatTree.Root),
 		TokenTree:    MPTPlus.MTP_Tree(db.GetDBInst(), last.TokenTree.Root),
-		Version:      HEADER_VERSION_MIXED,
+	}
+	if last.DelegateTree != nil {
+		block.DelegateTree = MPTPlus.MTP_Tree(db.GetDBInst(), last.DelegateTree.Root)
+	} else {
+		block.DelegateTree = MPTPlus.NewMTP(db.GetDBInst())
+	}
+	if last.VoteTree != nil {
+		block.VoteTree = MPTPlus.MTP_Tree(db.GetDBInst(), last.VoteTree.Root)
+	} else {
+		block.VoteTree = MPTPlus.NewMTP(db.GetDBInst())
+	}
+	block.SetVersion(HeaderVersion(block.Height))
+	// 新区块的状态修改先保存在内存中,区块打包或者校验完成之后再Commit
+	for _, tree := range block.trees() {
+		tree.Stage()
 	}
 
 	return block
 }
 
+// 返回区块头中所有的状态树,DelegateTree和VoteTree可能为空
+func (header *Header) trees() []*MPTPlus.MTP {
+	trees := []*MPTPlus.MTP{header.StatTree, header.TokenTree}
+	for _, tree := range []*MPTPlus.MTP{header.DelegateTree, header.VoteTree} {
+		if tree != nil {
+			trees = append(trees, tree)
+		}
+	}
+	return trees
+}
+
+// 设置区块头的版本,同时根据版本设置状态树新节点的编码方式
+// HEADER_VERSION_DELEGATE之前的区块没有DelegateTree,HEADER_VERSION_DPOS之前的区块没有VoteTree
+func (header *Header) SetVersion(version int) {
+	header.Version = version
+	if version < HEADER_VERSION_DELEGATE {
+		header.DelegateTree = nil
+	}
+	if version < HEADER_VERSION_DPOS {
+		header.VoteTree = nil
+	}
+	encoding := MPTPlus.NODE_ENCODING_JSON
+	if version >= HEADER_VERSION_RLP_MTP {
+		encoding = MPTPlus.NODE_ENCODING_RLP
+	}
+	for _, tree := range header.trees() {
+		tree.Encoding = encoding
+	}
+}
+
+// 把状态树在内存中的修改写入DB
+func (header *Header) Commit() error {
+	for _, tree := range header.trees() {
+		if err := tree.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (header *Header) NewTransaction(tx userevent.Transaction) userevent.TransactionReceipt {
 	account, err := header.GetAccount(tx.GetFrom())
 	if err != nil || account == nil || account.Gas < tx.Fee {
@@ -103,6 +221,10 @@ func (header *Header) NewTransaction(tx userevent.Transaction) userevent.Transac
 
 	if tx.Nonce != account.Nonce+1 {
 		return userevent.NewTransactionReceipt(tx, false, userevent.FailType_Invalid_NONCE)
+	} else if tx.Type == userevent.TX_TYPE_VOTE || tx.Type == userevent.TX_TYPE_UNVOTE {
+		return header.voteTransaction(tx, account)
+	} else if tx.Type != userevent.TX_TYPE_TRANSFER {
+		return header.delegateTransaction(tx, account)
 	} else if tx.TokenAddress == "" {
 		if account.GetAmount() < tx.Amount {
 			return userevent.NewTransactionReceipt(tx, false, userevent.FailType_NO_ENOUGH_AMOUNT)
@@ -141,15 +263,36 @@ func FromBytes2Header(data []byte) *Header {
 	return &header
 }
 
-func (header Header) ValidateBlockStat(next Header, transactions []userevent.Transaction, receipts userevent.Receipts) bool {
+func (header Header) ValidateBlockStat(chainId int64, next Header, transactions []userevent.Transaction, receipts userevent.Receipts, evidences Evidences) bool {
 	log.Info("Validating header stat merkler proof.")
 
+	// 版本由高度决定,不能低于父区块的版本,否则打包节点可以使用旧的编码和TxHash
+	if next.Version < header.Version || next.Version != HeaderVersion(next.Height) {
+		return false
+	}
+
 	//根据上一个区块头生成一个新的区块
 	_next := NewHeader(header, header.CaculateHash(), next.Coinbase)
 
+	//先执行区块中的作恶证据,证据必须和区块头中的EvidenceHash一致
+	if len(evidences) > 0 || len(next.EvidenceHash) > 0 {
+		if len(evidences) > MAX_EVIDENCES_PER_BLOCK || !bytes.Equal(evidences.Hash(), next.EvidenceHash) {
+			return false
+		}
+		for _, evidence := range evidences {
+			if !evidence.Validate() || _next.ApplyEvidence(evidence) != nil {
+				return false
+			}
+		}
+	}
+
 	//让新生成的区块执行peer传过来的body中的user events进行计算
 	if len(transactions) > 0 {
 		for i, transaction := range transactions {
+			// 签名在chainId的链上next高度无效的交易不能打包,和打包时的PackTransaction一致
+			if !userevent.ValidateTransaction(transaction, chainId, next.Height) {
+				return false
+			}
 			receipt := receipts[i]
 			if receipt.Fee != transaction.Fee {
 				transaction.Fee = receipt.Fee
@@ -158,19 +301,42 @@ func (header Header) ValidateBlockStat(next Header, transactions []userevent.Tra
 			if !receipt.EqualsTo(_receipt) {
 				return false
 			}
+			// 和打包时的Block.NewTransaction一致,成功交易的手续费在UpdateMiner中转给出块节点
+			if _receipt.Success {
+				_next.TotalFee += transaction.Fee
+			}
 		}
 	}
 
+	if err := _next.UpdateDelegates(); err != nil {
+		return false
+	}
 	_next.UpdateMiner()
 
 	// 判断默克尔根是否相同
 	if !bytes.Equal(next.StatTree.Root, _next.StatTree.Root) || !bytes.Equal(next.TokenTree.Root, _next.TokenTree.Root) {
 		return false
 	}
+	if !sameRoot(next.DelegateTree, _next.DelegateTree) || !sameRoot(next.VoteTree, _next.VoteTree) {
+		return false
+	}
 
+	// 校验通过的区块状态需要写入DB,后续区块会在此基础上继续计算
+	if err := _next.Commit(); err != nil {
+		log.Crit("Commit validated header stat failed, %s", err.Error())
+		return false
+	}
 	return true
 }
 
+// 两棵可能为空的树是否相同
+func sameRoot(tree1, tree2 *MPTPlus.MTP) bool {
+	if tree1 == nil || tree2 == nil {
+		return tree1 == nil && tree2 == nil
+	}
+	return bytes.Equal(tree1.Root, tree2.Root)
+}
+
 func (header *Header) UpdateMiner() {
 	account, err := header.GetAccount(header.Coinbase)
 	if account == nil || err != nil {
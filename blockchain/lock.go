@@ -0,0 +1,63 @@
+package blockchain
+
+import (
+	"bytes"
+	"sync"
+)
+
+/*
+*PreparedLocks记录委托人在每个高度锁定的prepare证书
+*委托人发送commit投票之前锁定证书,锁定之后只对锁定的区块发送prepare和commit投票
+*只有view更高的prepare证书才能替换已经锁定的证书,所以一个高度上得到超过2/3 commit投票的区块是唯一的
+ */
+type PreparedLocks struct {
+	locks  *sync.Map
+	locker *sync.Mutex
+}
+
+func NewPreparedLocks() PreparedLocks {
+	return PreparedLocks{
+		locks:  &sync.Map{},
+		locker: &sync.Mutex{},
+	}
+}
+
+// 返回height上锁定的prepare证书,没有锁定时返回nil
+func (locks PreparedLocks) Get(height int64) Votes {
+	obj, exist := locks.locks.Load(height)
+	if exist {
+		return obj.(Votes)
+	}
+	return nil
+}
+
+// 锁定prepare证书,已经锁定其他区块并且证书的view不更高时返回false
+func (locks PreparedLocks) Lock(prepared Votes) bool {
+	if len(prepared) == 0 {
+		return false
+	}
+	locks.locker.Lock()
+	defer locks.locker.Unlock()
+	vote := prepared[0].Vote
+	if locked := locks.Get(vote.BlockHeight); len(locked) > 0 && vote.View <= locked[0].Vote.View {
+		return bytes.Equal(vote.BlockHash, locked[0].Vote.BlockHash)
+	}
+	locks.locks.Store(vote.BlockHeight, prepared)
+	return true
+}
+
+// 没有锁定或者锁定的就是hash时才可以对hash投票
+func (locks PreparedLocks) Allow(height int64, hash []byte) bool {
+	locked := locks.Get(height)
+	return len(locked) == 0 || bytes.Equal(locked[0].Vote.BlockHash, hash)
+}
+
+// 区块写入之后删除这个高度及之前的锁定
+func (locks PreparedLocks) Clear(height int64) {
+	locks.locks.Range(func(key, value interface{}) bool {
+		if key.(int64) <= height {
+			locks.locks.Delete(key)
+		}
+		return true
+	})
+}
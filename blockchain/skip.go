@@ -0,0 +1,111 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+// 委托人在出块节点超时未出块时签名的跳过消息,表示同意跳过BlockHeight高度上第View个出块节点
+// View是这个高度上已经跳过的出块节点数量,同一个节点在一个高度上轮到多次时也可以区分
+type SkipDetail struct {
+	BlockchainId int64 `json:"blockchainId"`
+	BlockHeight  int64 `json:"blockHeight"`
+	View         int   `json:"view"`
+}
+
+// Prepared是发送方在这个高度锁定的prepare证书,其中的投票各自有签名,所以不包含在跳过消息的签名中
+type PeerSkipVote struct {
+	Skip      SkipDetail     `json:"skip"`
+	Peer      types.Peer     `json:"peer"`
+	Signature types.HexBytes `json:"signature"`
+	Prepared  Votes          `json:"prepared,omitempty"`
+}
+
+type SkipVotes []PeerSkipVote
+
+// 按照高度和view记录收到的跳过消息
+type SkipVoteResults struct {
+	results *sync.Map
+	locker  *sync.Mutex
+}
+
+func NewSkipVoteResults() SkipVoteResults {
+	return SkipVoteResults{
+		results: &sync.Map{},
+		locker:  &sync.Mutex{},
+	}
+}
+
+func (vote PeerSkipVote) Validate() bool {
+	pubKey, err := crypto.RecoverPubKey(vote.Msg(), vote.Signature)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(hex.EncodeToString(types.FromPubKeyToAddress(pubKey)), vote.Peer.Account) {
+		return false
+	}
+	return true
+}
+
+func (vote *PeerSkipVote) Sign(PrivKey []byte) error {
+	signature, err := crypto.Crypto(vote.Msg(), PrivKey)
+	if err != nil {
+		return err
+	} else {
+		vote.Signature = signature
+	}
+	return nil
+}
+
+func (vote PeerSkipVote) Bytes() []byte {
+	data, _ := json.Marshal(vote)
+	return data
+}
+
+func (vote PeerSkipVote) Msg() []byte {
+	data, _ := json.Marshal(vote.Skip)
+	return crypto.Sha3_256(data)
+}
+
+func skipKey(height int64, view int) string {
+	return fmt.Sprintf("%d_%d", height, view)
+}
+
+func (results SkipVoteResults) GetSkipVotes(height int64, view int) SkipVotes {
+	obj, exist := results.results.Load(skipKey(height, view))
+	if exist {
+		return obj.(SkipVotes)
+	}
+	return nil
+}
+
+// 记录跳过消息,同一个委托人对同一个高度和view只记录一次,返回当前的票数
+func (results SkipVoteResults) Insert(vote PeerSkipVote) int {
+	results.locker.Lock()
+	defer results.locker.Unlock()
+	votes := results.GetSkipVotes(vote.Skip.BlockHeight, vote.Skip.View)
+	for _, _vote := range votes {
+		if _vote.Peer.Equal(vote.Peer) {
+			return len(votes)
+		}
+	}
+	votes = append(append(SkipVotes{}, votes...), vote)
+	results.results.Store(skipKey(vote.Skip.BlockHeight, vote.Skip.View), votes)
+	return len(votes)
+}
+
+// 区块写入之后删除这个高度及之前的跳过消息
+func (results SkipVoteResults) Clear(height int64) {
+	results.results.Range(func(key, value interface{}) bool {
+		if votes := value.(SkipVotes); len(votes) > 0 && votes[0].Skip.BlockHeight <= height {
+			results.results.Delete(key)
+		}
+		return true
+	})
+}
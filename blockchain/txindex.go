@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"encoding/hex"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+)
+
+// 交易被打包的位置和执行结果,根据TransactionId索引
+type TxLocation struct {
+	TxId      string                       `json:"txId"`
+	BlockHash types.HexBytes               `json:"blockHash"`
+	Height    int64                        `json:"height"`
+	Index     int                          `json:"index"`
+	Receipt   userevent.TransactionReceipt `json:"receipt"`
+}
+
+// 地址的一条交易记录,SubTransaction记录父交易的TxId,Sub为true
+type HistoryEntry struct {
+	TxId         string         `json:"txId"`
+	Height       int64          `json:"height"`
+	Index        int            `json:"index"`
+	From         types.HexBytes `json:"from"`
+	To           types.HexBytes `json:"to"`
+	Amount       int64          `json:"amount"`
+	TokenAddress string         `json:"tokenAddress"`
+	Sub          bool           `json:"sub,omitempty"`
+	Success      bool           `json:"success"`
+}
+
+// 区块中每个交易和SubTransaction对应的记录,按照交易在区块中的顺序排列
+func HistoryEntries(height int64, transactions []userevent.Transaction, receipts []userevent.TransactionReceipt) []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(transactions))
+	for i, tx := range transactions {
+		success := i < len(receipts) && receipts[i].Success
+		entries = append(entries, HistoryEntry{
+			TxId:         tx.TransactionId(),
+			Height:       height,
+			Index:        i,
+			From:         tx.From,
+			To:           tx.To,
+			Amount:       tx.Amount,
+			TokenAddress: tx.TokenAddress,
+			Success:      success,
+		})
+		if i >= len(receipts) {
+			continue
+		}
+		for _, sub := range receipts[i].SubTransactions {
+			entries = append(entries, HistoryEntry{
+				TxId:         tx.TransactionId(),
+				Height:       height,
+				Index:        i,
+				From:         sub.From,
+				To:           sub.To,
+				Amount:       sub.Amount,
+				TokenAddress: hex.EncodeToString(sub.TokenAddress),
+				Sub:          true,
+				Success:      success,
+			})
+		}
+	}
+	return entries
+}
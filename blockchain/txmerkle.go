@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+// 叶子节点、中间节点和根的hash使用不同的前缀,避免用中间节点伪造叶子节点
+const (
+	TX_MERKLE_LEAF_PREFIX = 0x00
+	TX_MERKLE_NODE_PREFIX = 0x01
+	TX_MERKLE_ROOT_PREFIX = 0x02
+)
+
+/*
+*TxProof是交易在区块交易默克尔树中的证明
+*Branch是从叶子节点向上每一层的兄弟节点,节点数为奇数时最后一个节点直接进入上一层,这一层没有兄弟节点
+*哪一层没有兄弟节点由Total决定,所以根是最上层节点和叶子数量的hash,修改Total的证明不能通过校验
+*校验时需要交易、回执和区块头中的TxHash,不需要下载整个区块体
+ */
+type TxProof struct {
+	Index  int              `json:"index"`
+	Total  int              `json:"total"`
+	Branch []types.HexBytes `json:"branch"`
+}
+
+// 计算区块头中的TxHash,HEADER_VERSION_TX_MERKLE之前是交易列表JSON的hash
+func CaculateTxHash(version int, transactions userevent.Transactions, receipts userevent.Receipts) []byte {
+	if version < HEADER_VERSION_TX_MERKLE {
+		return crypto.Sha3_256(transactions.Bytes())
+	}
+	if len(transactions) != len(receipts) {
+		return nil
+	}
+	return TxMerkleRoot(TxLeaves(transactions, receipts))
+}
+
+// 叶子节点由交易的TxId和回执的hash组成
+func TxLeaf(tx userevent.Transaction, receipt userevent.TransactionReceipt) []byte {
+	receiptHash := crypto.Sha3_256(receipt.Bytes())
+	data := make([]byte, 0, 1+2*len(receiptHash))
+	data = append(data, TX_MERKLE_LEAF_PREFIX)
+	data = append(data, tx.TxId()...)
+	data = append(data, receiptHash...)
+	return crypto.Sha3_256(data)
+}
+
+func TxLeaves(transactions []userevent.Transaction, receipts []userevent.TransactionReceipt) [][]byte {
+	leaves := make([][]byte, 0, len(transactions))
+	for i := range transactions {
+		leaves = append(leaves, TxLeaf(transactions[i], receipts[i]))
+	}
+	return leaves
+}
+
+func txMerkleNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, TX_MERKLE_NODE_PREFIX)
+	data = append(data, left...)
+	data = append(data, right...)
+	return crypto.Sha3_256(data)
+}
+
+// 计算上一层的节点,最后一个没有兄弟的节点直接进入上一层
+func txMerkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, txMerkleNode(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+// 根由最上层节点和叶子的数量组成
+func txMerkleRoot(top []byte, total int) []byte {
+	data := make([]byte, 9, 9+len(top))
+	data[0] = TX_MERKLE_ROOT_PREFIX
+	binary.BigEndian.PutUint64(data[1:], uint64(total))
+	data = append(data, top...)
+	return crypto.Sha3_256(data)
+}
+
+// 逐层计算默克尔树的根,没有交易时为空数据的hash
+func TxMerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return crypto.Sha3_256(nil)
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = txMerkleLevel(level)
+	}
+	return txMerkleRoot(level[0], len(leaves))
+}
+
+// 生成第index个交易的证明,index越界时返回nil
+func ProveTx(leaves [][]byte, index int) *TxProof {
+	if index < 0 || index >= len(leaves) {
+		return nil
+	}
+	proof := &TxProof{Index: index, Total: len(leaves), Branch: make([]types.HexBytes, 0)}
+	level := leaves
+	for i := index; len(level) > 1; i /= 2 {
+		if sibling := i ^ 1; sibling < len(level) {
+			proof.Branch = append(proof.Branch, level[sibling])
+		}
+		level = txMerkleLevel(level)
+	}
+	return proof
+}
+
+// 根据交易和回执校验证明,root为区块头中的TxHash
+func (proof TxProof) Verify(root []byte, tx userevent.Transaction, receipt userevent.TransactionReceipt) bool {
+	if proof.Index < 0 || proof.Index >= proof.Total {
+		return false
+	}
+	hash, used := TxLeaf(tx, receipt), 0
+	for i, n := proof.Index, proof.Total; n > 1; i, n = i/2, (n+1)/2 {
+		if i%2 == 0 && i+1 >= n {
+			continue
+		}
+		if used >= len(proof.Branch) {
+			return false
+		}
+		if i%2 == 1 {
+			hash = txMerkleNode(proof.Branch[used], hash)
+		} else {
+			hash = txMerkleNode(hash, proof.Branch[used])
+		}
+		used++
+	}
+	return used == len(proof.Branch) && bytes.Equal(txMerkleRoot(hash, proof.Total), root)
+}
@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+func TestTxMerkleProof(t *testing.T) {
+	for total := 1; total <= 7; total++ {
+		txs, receipts := make(userevent.Transactions, 0), make(userevent.Receipts, 0)
+		for i := 0; i < total; i++ {
+			tx := userevent.NewTransaction(crypto.Sha3_256([]byte{0}), crypto.Sha3_256([]byte{1}), 0, int64(i+1), 1, int64(i+1), "", "")
+			txs = append(txs, *tx)
+			receipts = append(receipts, userevent.NewTransactionReceipt(*tx, true, userevent.FailType_SUCCESS))
+		}
+		leaves := TxLeaves(txs, receipts)
+		root := CaculateTxHash(HEADER_VERSION_TX_MERKLE, txs, receipts)
+		if !bytes.Equal(root, TxMerkleRoot(leaves)) {
+			t.Fatalf("total %d: unexpected root", total)
+		}
+		for i := 0; i < total; i++ {
+			proof := ProveTx(leaves, i)
+			if proof == nil || !proof.Verify(root, txs[i], receipts[i]) {
+				t.Fatalf("total %d: proof of transaction %d should be valid", total, i)
+			}
+			failed := receipts[i]
+			failed.Success = false
+			if proof.Verify(root, txs[i], failed) {
+				t.Fatalf("total %d: proof with a changed receipt should be invalid", total)
+			}
+			if other := (i + 1) % total; other != i && proof.Verify(root, txs[other], receipts[other]) {
+				t.Fatalf("total %d: proof of transaction %d should not verify transaction %d", total, i, other)
+			}
+			// 修改Total可以改变哪一层没有兄弟节点,根中包含了叶子数量,所以证明不能通过校验
+			for n := i + 1; n <= 8; n++ {
+				resized := *proof
+				resized.Total = n
+				if n != total && resized.Verify(root, txs[i], receipts[i]) {
+					t.Fatalf("total %d: proof with total changed to %d should be invalid", total, n)
+				}
+			}
+			moved := *proof
+			moved.Index = (i + 1) % total
+			if moved.Index != i && moved.Verify(root, txs[i], receipts[i]) {
+				t.Fatalf("total %d: proof with a changed index should be invalid", total)
+			}
+			if len(proof.Branch) > 0 {
+				truncated := *proof
+				truncated.Branch = proof.Branch[:len(proof.Branch)-1]
+				if truncated.Verify(root, txs[i], receipts[i]) {
+					t.Fatalf("total %d: truncated proof should be invalid", total)
+				}
+			}
+		}
+		if ProveTx(leaves, total) != nil {
+			t.Fatalf("total %d: proof out of range should be nil", total)
+		}
+	}
+}
+
+func TestCaculateTxHashVersion(t *testing.T) {
+	tx := userevent.NewTransaction(crypto.Sha3_256([]byte{0}), crypto.Sha3_256([]byte{1}), 0, 1, 1, 1, "", "")
+	txs := userevent.Transactions{*tx}
+	receipts := userevent.Receipts{userevent.NewTransactionReceipt(*tx, true, userevent.FailType_SUCCESS)}
+	if !bytes.Equal(CaculateTxHash(HEADER_VERSION_DPOS, txs, receipts), crypto.Sha3_256(txs.Bytes())) {
+		t.Fatal("legacy headers should keep the json hash")
+	}
+	if !bytes.Equal(CaculateTxHash(HEADER_VERSION_TX_MERKLE, txs, receipts), txMerkleRoot(TxLeaf(*tx, receipts[0]), 1)) {
+		t.Fatal("root of a single transaction should commit its leaf and the count")
+	}
+	if CaculateTxHash(HEADER_VERSION_TX_MERKLE, txs, nil) != nil {
+		t.Fatal("transactions without receipts should have no root")
+	}
+	proof := TxProof{Index: 0, Total: 1, Branch: []types.HexBytes{}}
+	if !proof.Verify(CaculateTxHash(HEADER_VERSION_TX_MERKLE, txs, receipts), *tx, receipts[0]) {
+		t.Fatal("proof of a single transaction should be valid")
+	}
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+)
+
+// 测试中启用所有版本,需要defer SetVersionHeights(nil)恢复默认的启用高度
+func enableAllVersions() {
+	if err := SetVersionHeights(make([]int64, HEADER_VERSION_LATEST+1)); err != nil {
+		panic(err)
+	}
+}
+
+func TestHeaderVersionHeights(t *testing.T) {
+	defer SetVersionHeights(nil)
+	if HeaderVersion(0) != HEADER_VERSION_MIXED || HeaderVersion(1<<40) != HEADER_VERSION_MIXED {
+		t.Fatal("existing chains should keep the mixed version without configuration")
+	}
+	if SetVersionHeights([]int64{10, 10}) == nil {
+		t.Fatal("version 0 should be activated at height 0")
+	}
+	enableAllVersions()
+	if HeaderVersion(1) != HEADER_VERSION_LATEST {
+		t.Fatal("all versions should be enabled from height 0")
+	}
+	if err := SetVersionHeights([]int64{0, 0, 0, 0, 0, 100}); err != nil {
+		t.Fatal(err)
+	}
+	if HeaderVersion(99) != HEADER_VERSION_DPOS || HeaderVersion(100) != HEADER_VERSION_TX_MERKLE {
+		t.Fatal("version should switch at the activation height")
+	}
+	if err := SetVersionHeights([]int64{0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if HeaderVersion(1<<40) != HEADER_VERSION_DELEGATE {
+		t.Fatal("versions without activation height should not be enabled")
+	}
+	if SetVersionHeights([]int64{0, 10, 5}) == nil {
+		t.Fatal("decreasing heights should be rejected")
+	}
+}
+
+func TestValidateBlockStatVersion(t *testing.T) {
+	log.InitLog("/tmp/blockchain_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	enableAllVersions()
+	defer SetVersionHeights(nil)
+
+	account := types.CreateAccount(crypto.Sha3_256([]byte{0}), 100)
+	genesisBlock := CreateGenesisBlock([]types.Account{account})
+	genesis := *genesisBlock.GetHeader()
+	miner := types.Peer{Account: hex.EncodeToString(account.Address)}
+	pack := func() Header {
+		block := CreateBlock(genesis, miner)
+		block.Finish()
+		return *block.GetHeader()
+	}
+
+	next := pack()
+	if !genesis.ValidateBlockStat(1, next, nil, nil, nil) {
+		t.Fatal("block with the scheduled version should be valid")
+	}
+	downgraded := next
+	downgraded.SetVersion(HEADER_VERSION_RLP_MTP)
+	if genesis.ValidateBlockStat(1, downgraded, nil, nil, nil) {
+		t.Fatal("block with a lower version should be rejected")
+	}
+
+	// 还没有到启用高度时,使用新版本的区块也不合法
+	if err := SetVersionHeights([]int64{0, 0, 0, 0, 0, 100}); err != nil {
+		t.Fatal(err)
+	}
+	if genesis.ValidateBlockStat(1, next, nil, nil, nil) {
+		t.Fatal("block with a version not yet activated should be rejected")
+	}
+	if old := pack(); old.Version != HEADER_VERSION_DPOS || !genesis.ValidateBlockStat(1, old, nil, nil, nil) {
+		t.Fatal("block before the activation height should use the previous version")
+	}
+}
@@ -9,6 +9,18 @@ import (
 
 	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/util"
+)
+
+/*
+*投票分为prepare和commit两个阶段
+*委托人校验区块之后发送prepare投票,收到超过2/3委托人的prepare投票之后发送commit投票
+*超过2/3委托人的commit投票组成voteResult,区块只有在有合法的voteResult时才能写入链中
+*同一个view中超过2/3委托人对同一个区块的prepare投票组成prepare证书,委托人在发送commit投票之前锁定这个证书
+ */
+const (
+	VOTE_PHASE_PREPARE = 0
+	VOTE_PHASE_COMMIT  = 1
 )
 
 var VoteResultManager VoteResults
@@ -22,6 +34,8 @@ type BlockVoteDetail struct {
 	BlockHash    types.HexBytes `json:"blockHash"`
 	BlockHeight  int64          `json:"blockHeight"`
 	VoteResult   bool           `json:"voteResult"`
+	Phase        int            `json:"phase,omitempty"`
+	View         int            `json:"view,omitempty"` // prepare投票是发送时的view,commit投票是prepare证书的view
 }
 
 type PeerBlockVote struct {
@@ -44,20 +58,45 @@ func NewVoteResults() VoteResults {
 	}
 }
 
+// 同一个委托人对一个区块的commit投票只记录一次,prepare投票在每个view分别记录
 func (vote1 PeerBlockVote) Equal(vote2 PeerBlockVote) bool {
-	return vote1.Peer.Equal(vote2.Peer) && bytes.EqualFold(vote1.Vote.BlockHash, vote2.Vote.BlockHash)
+	return vote1.Peer.Equal(vote2.Peer) && bytes.EqualFold(vote1.Vote.BlockHash, vote2.Vote.BlockHash) &&
+		vote1.Vote.Phase == vote2.Vote.Phase && (vote1.Vote.Phase == VOTE_PHASE_COMMIT || vote1.Vote.View == vote2.Vote.View)
 }
 
+func voteKey(phase int, hash string) string {
+	if phase == VOTE_PHASE_COMMIT {
+		return hash
+	}
+	return "prepare_" + hash
+}
+
+// 获取区块的commit投票,即可以写入链中的voteResult
 func (voteResults VoteResults) GetVoteResults(hash string) Votes {
-	obj, exist := voteResults.voteResults.Load(hash)
+	return voteResults.GetVotes(VOTE_PHASE_COMMIT, hash)
+}
+
+func (voteResults VoteResults) GetVotes(phase int, hash string) Votes {
+	obj, exist := voteResults.voteResults.Load(voteKey(phase, hash))
 	if exist {
 		return obj.(Votes)
 	}
 	return nil
 }
 
-func (voteResults VoteResults) SetVoteResults(hash string, votes Votes) {
-	voteResults.voteResults.Store(hash, votes)
+// 获取区块在view中的prepare投票
+func (voteResults VoteResults) GetPrepared(hash string, view int) Votes {
+	prepared := make(Votes, 0)
+	for _, vote := range voteResults.GetVotes(VOTE_PHASE_PREPARE, hash) {
+		if vote.Vote.View == view {
+			prepared = append(prepared, vote)
+		}
+	}
+	return prepared
+}
+
+func (voteResults VoteResults) SetVotes(phase int, hash string, votes Votes) {
+	voteResults.voteResults.Store(voteKey(phase, hash), votes)
 }
 
 func (vote PeerBlockVote) Validate() bool {
@@ -92,7 +131,7 @@ func (vote PeerBlockVote) Msg() []byte {
 }
 
 func (voteResults VoteResults) Insert(vote PeerBlockVote) {
-	votes := voteResults.GetVoteResults(hex.EncodeToString(vote.Vote.BlockHash))
+	votes := voteResults.GetVotes(vote.Vote.Phase, hex.EncodeToString(vote.Vote.BlockHash))
 	if len(votes) > 0 {
 		for _, _vote := range votes {
 			if vote.Equal(_vote) {
@@ -104,19 +143,25 @@ func (voteResults VoteResults) Insert(vote PeerBlockVote) {
 		votes = make([]PeerBlockVote, 0)
 		votes = append(votes, vote)
 	}
-	voteResults.SetVoteResults(hex.EncodeToString(vote.Vote.BlockHash), votes)
+	voteResults.SetVotes(vote.Vote.Phase, hex.EncodeToString(vote.Vote.BlockHash), votes)
 }
 
-func (voteResults VoteResults) Number(blockHash []byte) int {
-	votes := voteResults.GetVoteResults(hex.EncodeToString(blockHash))
+func (voteResults VoteResults) Number(phase int, blockHash []byte) int {
+	votes := voteResults.GetVotes(phase, hex.EncodeToString(blockHash))
 	return len(votes)
 }
 
-func (voteResults VoteResults) Broadcasted(blockHash []byte) bool {
-	_, exist := voteResults.broadcast.Load(hex.EncodeToString(blockHash))
+func (voteResults VoteResults) Broadcasted(key string) bool {
+	_, exist := voteResults.broadcast.Load(key)
 	return exist
 }
 
+// 标记key对应的消息已经广播,已经标记过时返回false,保证同一个消息只广播一次
+func (voteResults VoteResults) SetBroadcasted(key string) bool {
+	_, loaded := voteResults.broadcast.LoadOrStore(key, true)
+	return !loaded
+}
+
 func (vote Votes) Len() int {
 	return len(vote)
 }
@@ -134,19 +179,48 @@ func (vote Votes) Bytes() []byte {
 	return data
 }
 
-func (votes Votes) Validate() bool {
-	if len(votes) == 0 {
+// 校验voteResult,必须是peers中超过2/3的不同委托人对同一个区块的commit投票
+func (votes Votes) Validate(peers types.Peers) bool {
+	return votes.validate(peers, VOTE_PHASE_COMMIT)
+}
+
+// 校验prepare证书,必须是peers中超过2/3的不同委托人在同一个view对同一个区块的prepare投票
+func (votes Votes) ValidatePrepared(peers types.Peers) bool {
+	return votes.validate(peers, VOTE_PHASE_PREPARE)
+}
+
+func (votes Votes) validate(peers types.Peers, phase int) bool {
+	if len(votes) == 0 || len(votes) < util.MoreThanTwoThirds(len(peers)) {
 		return false
 	}
 	for i, vote := range votes {
-		if !vote.Validate() || !vote.Vote.VoteResult {
+		if !vote.Validate() || !vote.Vote.VoteResult || vote.Vote.Phase != phase {
+			return false
+		}
+		if !bytes.Equal(vote.Vote.BlockHash, votes[0].Vote.BlockHash) || vote.Vote.BlockHeight != votes[0].Vote.BlockHeight {
+			return false
+		}
+		if phase == VOTE_PHASE_PREPARE && vote.Vote.View != votes[0].Vote.View {
+			return false
+		}
+		if !inPeers(peers, vote.Peer) {
 			return false
 		}
 		for j, _vote := range votes {
-			if i != j && vote.Equal(_vote) {
+			if i != j && strings.EqualFold(vote.Peer.Account, _vote.Peer.Account) {
 				return false
 			}
 		}
 	}
 	return true
 }
+
+// 签名只能证明投票来自Account,所以按照Account判断是否是委托人
+func inPeers(peers types.Peers, peer types.Peer) bool {
+	for _, _peer := range peers {
+		if strings.EqualFold(_peer.Account, peer.Account) {
+			return true
+		}
+	}
+	return false
+}
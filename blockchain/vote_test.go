@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+func newVoters(n int) (types.Peers, [][]byte) {
+	peers, privKeys := make(types.Peers, 0, n), make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		pub, priv := crypto.GenerateKeyPair()
+		peers = append(peers, types.Peer{Account: hex.EncodeToString(types.FromPubKeyToAddress(pub))})
+		privKeys = append(privKeys, priv)
+	}
+	return peers, privKeys
+}
+
+func signVotes(t *testing.T, peers types.Peers, privKeys [][]byte, phase int) Votes {
+	votes := make(Votes, 0, len(peers))
+	for i := range peers {
+		vote := PeerBlockVote{
+			Vote: BlockVoteDetail{
+				BlockchainId: 1,
+				BlockHash:    crypto.Sha3_256([]byte("block")),
+				BlockHeight:  1,
+				VoteResult:   true,
+				Phase:        phase,
+			},
+			Peer: peers[i],
+		}
+		if err := vote.Sign(privKeys[i]); err != nil {
+			t.Fatal(err)
+		}
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+func TestVotesValidate(t *testing.T) {
+	peers, privKeys := newVoters(4)
+	commits := signVotes(t, peers, privKeys, VOTE_PHASE_COMMIT)
+
+	// 4个委托人需要3个commit投票
+	if !commits[:3].Validate(peers) {
+		t.Fatal("2f+1 commit votes should be valid")
+	}
+	if commits[:2].Validate(peers) {
+		t.Fatal("bare majority should not be valid")
+	}
+	if Votes(append(Votes{}, commits[0], commits[0], commits[1])).Validate(peers) {
+		t.Fatal("duplicated voter should not be valid")
+	}
+	if signVotes(t, peers, privKeys, VOTE_PHASE_PREPARE).Validate(peers) {
+		t.Fatal("prepare votes should not be a vote result")
+	}
+	others, _ := newVoters(4)
+	if commits[:3].Validate(others) {
+		t.Fatal("votes from non-delegates should not be valid")
+	}
+}
+
+func TestPreparedLocks(t *testing.T) {
+	peers, privKeys := newVoters(4)
+	prepared := func(hash []byte, view int) Votes {
+		votes := signVotes(t, peers, privKeys, VOTE_PHASE_PREPARE)
+		for i := range votes {
+			votes[i].Vote.BlockHash, votes[i].Vote.View = hash, view
+			if err := votes[i].Sign(privKeys[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return votes[:3]
+	}
+	block1, block2 := crypto.Sha3_256([]byte("block1")), crypto.Sha3_256([]byte("block2"))
+	if !prepared(block1, 1).ValidatePrepared(peers) {
+		t.Fatal("2f+1 prepare votes in one view should be a prepared certificate")
+	}
+	mixed := append(prepared(block1, 1)[:2], prepared(block1, 2)[2])
+	if mixed.ValidatePrepared(peers) || prepared(block1, 1).Validate(peers) {
+		t.Fatal("prepare votes from different views should not be a certificate")
+	}
+
+	locks := NewPreparedLocks()
+	if !locks.Allow(1, block2) || !locks.Lock(prepared(block1, 1)) {
+		t.Fatal("first certificate should be locked")
+	}
+	if locks.Allow(1, block2) || !locks.Allow(1, block1) || !locks.Allow(2, block2) {
+		t.Fatal("locked height should only allow the locked block")
+	}
+	if locks.Lock(prepared(block2, 0)) || locks.Lock(prepared(block2, 1)) {
+		t.Fatal("certificate of another block in a view not higher should not unlock")
+	}
+	if !locks.Lock(prepared(block1, 0)) || locks.Get(1)[0].Vote.View != 1 {
+		t.Fatal("lower certificate of the locked block should keep the lock")
+	}
+	if !locks.Lock(prepared(block2, 2)) || !bytes.Equal(locks.Get(1)[0].Vote.BlockHash, block2) {
+		t.Fatal("higher certificate should unlock")
+	}
+	if locks.Clear(1); locks.Get(1) != nil {
+		t.Fatal("lock should be cleared after the block is saved")
+	}
+}
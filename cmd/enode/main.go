@@ -9,11 +9,15 @@ import (
 
 	_ "github.com/OpenOCC/OCC/api"
 
+	"github.com/OpenOCC/OCC/MPTPlus"
+	"github.com/OpenOCC/OCC/blockchain"
 	"github.com/OpenOCC/OCC/conf"
+	"github.com/OpenOCC/OCC/core/userevent"
 	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/log"
 	"github.com/OpenOCC/OCC/node"
 	"github.com/OpenOCC/OCC/param"
+	"github.com/OpenOCC/OCC/pruner"
 
 	"github.com/OpenOCC/xserver/x_http"
 )
@@ -25,15 +29,19 @@ const (
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU() - 1)
 	var (
-		help bool
-		ver  bool
-		m    string
-		cfg  string
+		help   bool
+		ver    bool
+		m      string
+		cfg    string
+		gcmode string
+		keep   int64
 	)
 	flag.BoolVar(&help, "h", false, "this help")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
 	flag.StringVar(&m, "m", "adaptive", "specific node node: full sync OR fast sync OR delegate")
 	flag.StringVar(&cfg, "c", "genesis.json", "set genesis.json file and start")
+	flag.StringVar(&gcmode, "gcmode", pruner.GC_MODE_ARCHIVE, "state storage mode: archive OR pruned")
+	flag.Int64Var(&keep, "keep", 128, "number of recent heights whose state is kept in pruned mode, at least 128")
 	flag.Parse()
 
 	if help {
@@ -46,6 +54,16 @@ func init() {
 		os.Exit(0)
 	}
 
+	// 还没有不可逆的区块可以回滚,保留的状态少于回滚的深度时无法从父区块重新计算
+	if gcmode == pruner.GC_MODE_PRUNED && keep < pruner.MIN_KEEP {
+		fmt.Printf("Invalid keep %d, must be at least %d \n", keep, pruner.MIN_KEEP)
+		os.Exit(-1)
+	}
+
+	if flag.Arg(0) == "verify-state" {
+		os.Exit(verifyState(cfg, flag.Args()[1:]))
+	}
+
 	err := InitService(cfg)
 	if err != nil {
 		fmt.Printf("Init service failed, %v \n", err)
@@ -54,6 +72,15 @@ func init() {
 
 	node.Init(m)
 
+	switch gcmode {
+	case pruner.GC_MODE_ARCHIVE:
+	case pruner.GC_MODE_PRUNED:
+		pruner.NewPruner(node.GetMainChain().ChainId, keep).Run(node.GetMainChain())
+	default:
+		fmt.Printf("Invalid gcmode %s \n", gcmode)
+		os.Exit(-1)
+	}
+
 	http.HandleFunc("/", x_http.Service)
 }
 
@@ -107,11 +134,16 @@ func initPeerId() error {
 }
 
 func initConfig(confPath string) error {
-	return conf.InitConfig(confPath)
+	if err := conf.InitConfig(confPath); err != nil {
+		return err
+	}
+	userevent.SetLegacySignHeight(conf.EKTConfig.LegacySignHeight)
+	return blockchain.SetVersionHeights(conf.EKTConfig.HeaderVersionHeights)
 }
 
 func initDB() {
-	db.InitEKTDB(conf.EKTConfig.DBPath)
+	db.InitEKTDB(conf.EKTConfig.DBPath, conf.EKTConfig.DBCacheSize*1024*1024)
+	MPTPlus.SetNodeCacheSize(conf.EKTConfig.NodeCacheSize * 1024 * 1024)
 }
 
 func initLog() error {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/OpenOCC/OCC/MPTPlus"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb"
+)
+
+// enode verify-state --height N 校验指定高度的状态树在本地DB中是否完整
+func verifyState(cfg string, args []string) int {
+	var (
+		height  int64
+		workers int
+	)
+	flags := flag.NewFlagSet("verify-state", flag.ExitOnError)
+	flags.Int64Var(&height, "height", -1, "height of the header whose state is verified, default is the last header")
+	flags.IntVar(&workers, "workers", runtime.NumCPU(), "number of goroutines walking the tries")
+	flags.Parse(args)
+
+	if err := InitService(cfg); err != nil {
+		fmt.Printf("Init service failed, %v \n", err)
+		return -1
+	}
+
+	header := encapdb.GetLastHeader(1)
+	if height >= 0 {
+		header = encapdb.GetHeaderByHeight(1, height)
+	}
+	if header == nil || header.StatTree == nil || header.TokenTree == nil {
+		fmt.Printf("Header at height %d not found \n", height)
+		return -1
+	}
+
+	start := time.Now()
+	fmt.Printf("Verifying state at height %d, statRoot: %s, tokenRoot: %s \n",
+		header.Height, hex.EncodeToString(header.StatTree.Root), hex.EncodeToString(header.TokenTree.Root))
+	roots := [][]byte{header.StatTree.Root, header.TokenTree.Root}
+	if header.DelegateTree != nil {
+		fmt.Printf("delegateRoot: %s \n", hex.EncodeToString(header.DelegateTree.Root))
+		roots = append(roots, header.DelegateTree.Root)
+	}
+	if header.VoteTree != nil {
+		fmt.Printf("voteRoot: %s \n", hex.EncodeToString(header.VoteTree.Root))
+		roots = append(roots, header.VoteTree.Root)
+	}
+	report := MPTPlus.VerifyTrie(db.GetDBInst(), roots, workers)
+	for _, issue := range report.Missing {
+		fmt.Printf("missing node %s: %s \n", hex.EncodeToString(issue.Hash), issue.Reason)
+	}
+	for _, issue := range report.Corrupt {
+		fmt.Printf("corrupt node %s: %s \n", hex.EncodeToString(issue.Hash), issue.Reason)
+	}
+	fmt.Printf("Checked %d nodes and %d values in %v, %d missing, %d corrupt \n",
+		report.Nodes, report.Values, time.Since(start), len(report.Missing), len(report.Corrupt))
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -17,6 +17,10 @@ type EKTConf struct {
 	GenesisBlockAccounts []types.Account `json:"genesisBlock"`
 	PrivateKey           types.HexBytes  `json:"privateKey"`
 	Env                  string          `json:"env"`
+	DBCacheSize          int64           `json:"dbCacheSize"`          // levelDB读缓存的容量,单位MB
+	NodeCacheSize        int64           `json:"nodeCacheSize"`        // 已解码的树节点缓存的容量,单位MB
+	LegacySignHeight     int64           `json:"legacySignHeight"`     // 从此高度开始不再接受旧格式签名的交易,不设置时一直接受
+	HeaderVersionHeights []int64         `json:"headerVersionHeights"` // 第i个元素是区块头版本i的启用高度,第一个元素必须是0,不设置时只启用HEADER_VERSION_MIXED
 }
 
 var EKTConfig EKTConf
@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"github.com/OpenOCC/OCC/occclient"
 	"github.com/OpenOCC/OCC/encapdb"
 	"sync"
@@ -10,19 +12,41 @@ import (
 	"github.com/OpenOCC/OCC/blockchain"
 	"github.com/OpenOCC/OCC/conf"
 	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
 	"github.com/OpenOCC/OCC/ctxlog"
 	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/log"
 	"github.com/OpenOCC/OCC/param"
+	"github.com/OpenOCC/OCC/util"
 )
 
+// 出块节点超过SkipTimeout没有出块时,委托人节点发送跳过消息
+var SkipTimeout = 2 * blockchain.BackboneBlockInterval
+
+// 区块的时间戳最多可以比本地时间超前MaxClockDrift
+var MaxClockDrift = blockchain.BackboneBlockInterval / 2
+
+// 委托人每个interval发送一次心跳,超过HeartbeatTimeout没有收到心跳的委托人认为不存活,更早的心跳被当作过期心跳丢弃
+var HeartbeatTimeout = 3 * blockchain.BackboneBlockInterval
+
 type DbftConsensus struct {
 	Round        *types.Round
 	Blockchain   *blockchain.BlockChain
 	BlockManager *blockchain.BlockManager
 	VoteResults  blockchain.VoteResults
+	SkipVotes    blockchain.SkipVoteResults
+	Locks        blockchain.PreparedLocks
+	Detector     blockchain.EquivocationDetector
+	Liveness     types.LivenessTable
 	Client       occclient.IClient
 	Locker       sync.RWMutex
+
+	// 当前节点的身份和时钟,模拟环境中一个进程内运行多个委托人时分别设置
+	Node       types.Peer
+	PrivateKey []byte
+	Clock      util.Clock
+	// 异步执行打包和分叉切换,模拟环境中放入事件队列以保证执行顺序确定
+	Async func(f func())
 }
 
 func NewDbftConsensus(Blockchain *blockchain.BlockChain, client occclient.IClient) *DbftConsensus {
@@ -31,15 +55,43 @@ func NewDbftConsensus(Blockchain *blockchain.BlockChain, client occclient.IClien
 		Blockchain:   Blockchain,
 		BlockManager: blockchain.NewBlockManager(),
 		VoteResults:  blockchain.NewVoteResults(),
+		SkipVotes:    blockchain.NewSkipVoteResults(),
+		Locks:        blockchain.NewPreparedLocks(),
+		Detector:     blockchain.NewEquivocationDetector(),
+		Liveness:     types.NewLivenessTable(),
 		Client:       client,
 		Locker:       sync.RWMutex{},
+		Node:         conf.EKTConfig.Node,
+		PrivateKey:   conf.EKTConfig.GetPrivateKey(),
+		Clock:        util.RealClock{},
+		Async:        func(f func()) { go f() },
 	}
 }
 
+// 当前的毫秒时间戳
+func (dbft DbftConsensus) now() int64 {
+	return util.UnixMilli(dbft.Clock)
+}
+
 func (dbft DbftConsensus) GetRound() types.Round {
 	return dbft.Round.Clone()
 }
 
+// 返回header之后生效的委托人集合,链上没有记录委托人集合时使用param中的委托人节点
+func DelegatesOf(header blockchain.Header) types.Peers {
+	if peers := header.GetDelegates(); len(peers) > 0 {
+		return peers
+	}
+	return param.MainChainDelegateNode
+}
+
+// 根据链上的状态重新设置Round的委托人集合
+func (dbft DbftConsensus) updateDelegates(header blockchain.Header) {
+	peers := DelegatesOf(header)
+	dbft.Round.SetPeers(peers)
+	dbft.Client.SetPeers(peers)
+}
+
 // 校验从其他委托人节点过来的区块数据
 func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockchain.Block) {
 	dbft.Locker.Lock()
@@ -47,11 +99,26 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 
 	header := block.GetHeader()
 	ctxlog.Log("header", header)
+	if header == nil {
+		return
+	}
+	if evidence := dbft.Detector.CheckBlock(block.Miner, blockchain.SignedHeader{Header: header, Signature: block.Signature}); evidence != nil {
+		dbft.SaveEvidence(*evidence)
+	}
+	// 签名和Miner不在区块hash中,同一个hash的区块可能带有伪造的签名,所以每次收到都要校验
+	// 校验通过之后才放入BlockManager,校验失败只对第一次收到的区块记录错误状态
+	if status := dbft.ValidateProposal(block); status != blockchain.BLOCK_TO_BE_HANDLE {
+		ctxlog.Log("invalid proposal", status)
+		if dbft.BlockManager.GetBlockStatus(block.Hash) < 0 {
+			dbft.BlockManager.SetBlockStatus(block.Hash, status)
+		}
+		return
+	}
 	dbft.BlockManager.Insert(&block)
 
 	status := dbft.BlockManager.GetBlockStatus(header.CaculateHash())
 	ctxlog.Log("status", status)
-	if status == blockchain.BLOCK_SAVED ||
+	if status >= blockchain.BLOCK_SAVED ||
 		(status > blockchain.BLOCK_ERROR_START && status < blockchain.BLOCK_ERROR_END) ||
 		status == blockchain.BLOCK_VOTED {
 		//如果区块已经写入链中 or 是一个有问题的区块 or 已经投票成功 直接返回
@@ -59,11 +126,14 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 	}
 
 	if status == blockchain.BLOCK_VALID {
-		if lastVoteTime := dbft.BlockManager.GetVoteTime(block.GetHeader().Height); lastVoteTime+int64(blockchain.BackboneBlockInterval)/1e6 > time.Now().UnixNano()/1e6 {
+		if !dbft.Locks.Allow(header.Height, block.Hash) {
+			ctxlog.Log("Locked", true)
+			return
+		}
+		if lastVoteTime := dbft.BlockManager.GetVoteTime(block.GetHeader().Height); lastVoteTime+int64(blockchain.BackboneBlockInterval)/1e6 > dbft.now() {
 			ctxlog.Log("Voted this height", true)
 			return
 		}
-		dbft.BlockManager.SetVoteTime(block.GetHeader().Height, time.Now().UnixNano()/1e6)
 		ctxlog.Log("SendVote", true)
 		dbft.SendVote(*header)
 		dbft.BlockManager.SetBlockStatus(header.CaculateHash(), blockchain.BLOCK_VOTED)
@@ -72,7 +142,7 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 
 	// 判断此区块是否是一个interval之前打包的，如果是则放弃vote
 	// unit： ms    单位：ms
-	blockLatencyTime := int(time.Now().UnixNano()/1e6 - header.Timestamp) // 从节点打包到当前节点的延迟，单位ms
+	blockLatencyTime := int(dbft.now() - header.Timestamp) // 从节点打包到当前节点的延迟，单位ms
 	blockInterval := int(blockchain.BackboneBlockInterval * 4 / 3)        // 当前链的打包间隔，单位nanoSecond,计算为ms
 	if blockLatencyTime > blockInterval {
 		ctxlog.Log("More than an interval", true)
@@ -80,7 +150,7 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 		return
 	}
 
-	if !dbft.ValidatePackRight(header.Timestamp, dbft.Blockchain.LastHeader().Timestamp, block.Miner) {
+	if !dbft.ValidatePackRight(block.Miner) {
 		ctxlog.Log("Invalid node", true)
 		return
 	}
@@ -89,8 +159,16 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 	receipts := block.GetTxReceipts()
 	ctxlog.Log("txs", transactions)
 	ctxlog.Log("receipts", receipts)
+	ctxlog.Log("evidences", block.Evidences)
 	// 对区块进行validate和recover，如果区块数据没问题，则发送投票给其他节点
-	if dbft.Blockchain.LastHeader().ValidateBlockStat(*header, transactions, receipts) {
+	if dbft.ValidateEvidences(block.Evidences) &&
+		dbft.Blockchain.LastHeader().ValidateBlockStat(dbft.Blockchain.ChainId, *header, transactions, receipts, block.Evidences) {
+		// 已经锁定这个高度的其他区块时只记录校验结果,收到更高view的prepare证书之后才可以commit
+		if !dbft.Locks.Allow(header.Height, block.Hash) {
+			ctxlog.Log("Locked", true)
+			dbft.BlockManager.SetBlockStatus(header.CaculateHash(), blockchain.BLOCK_VALID)
+			return
+		}
 		ctxlog.Log("SendVote", true)
 		dbft.BlockManager.SetBlockStatus(header.CaculateHash(), blockchain.BLOCK_VOTED)
 		dbft.SendVote(*header)
@@ -100,7 +178,33 @@ func (dbft DbftConsensus) BlockFromPeer(ctxlog *ctxlog.ContextLog, block blockch
 	}
 }
 
-// 校验从其他委托人节点来的区块成功之后发送投票
+/*
+*校验其他节点广播的区块,校验通过返回BLOCK_TO_BE_HANDLE,否则返回对应的错误状态
+*Hash必须和区块头一致,签名必须由Miner的私钥生成,Miner必须是当前的委托人
+*时间戳必须晚于上一个区块,并且不能比本地时间超前MaxClockDrift,PreviousHash必须是本地最新区块的hash
+ */
+func (dbft DbftConsensus) ValidateProposal(block blockchain.Block) int {
+	header := block.GetHeader()
+	if header == nil || !bytes.Equal(block.Hash, header.CaculateHash()) {
+		return blockchain.BLOCK_ERROR_HASH
+	}
+	if !block.ValidateSign() {
+		return blockchain.BLOCK_ERROR_SIGN
+	}
+	if dbft.GetRound().IndexOf(block.Miner.Account) < 0 {
+		return blockchain.BLOCK_ERROR_MINER
+	}
+	last := dbft.Blockchain.LastHeader()
+	if header.Timestamp <= last.Timestamp || header.Timestamp > dbft.now()+int64(MaxClockDrift)/1e6 {
+		return blockchain.BLOCK_ERROR_PACK_TIME
+	}
+	if !bytes.Equal(header.PreviousHash, last.CaculateHash()) {
+		return blockchain.BLOCK_ERROR_PREVIOUS_HASH
+	}
+	return blockchain.BLOCK_TO_BE_HANDLE
+}
+
+// 校验从其他委托人节点来的区块成功之后发送prepare投票
 func (dbft DbftConsensus) SendVote(header blockchain.Header) {
 	// 同一个节点在一个出块interval内对一个高度只会投票一次，所以先校验是否进行过投票
 	//log.Info("Validating send vote interval.")
@@ -108,7 +212,7 @@ func (dbft DbftConsensus) SendVote(header blockchain.Header) {
 	lastVoteTime := dbft.BlockManager.GetVoteTime(header.Height)
 	if lastVoteTime > 0 {
 		// 距离投票的毫秒数
-		intervalInFact := int(time.Now().UnixNano()/1e6 - lastVoteTime)
+		intervalInFact := int(dbft.now() - lastVoteTime)
 		// 规则指定的毫秒数
 		intervalInRule := int(blockchain.BackboneBlockInterval / 1e6)
 
@@ -120,21 +224,48 @@ func (dbft DbftConsensus) SendVote(header blockchain.Header) {
 	}
 
 	// 记录此次投票的时间
-	dbft.BlockManager.SetVoteTime(header.Height, time.Now().UnixNano()/1e6)
+	dbft.BlockManager.SetVoteTime(header.Height, dbft.now())
+
+	dbft.sendVote(header.CaculateHash(), header.Height, dbft.GetRound().View, blockchain.VOTE_PHASE_PREPARE)
+}
+
+// 收到prepare证书之后锁定区块并发送commit投票,同一个高度的每个view只对一个区块发送commit投票
+// 已经锁定其他区块时只有view更高的证书才能解除锁定,出块节点被跳过之后委托人仍然可以commit新的区块
+func (dbft DbftConsensus) CommitPrepared(prepared blockchain.Votes) {
+	vote := prepared[0].Vote
+	status := dbft.BlockManager.GetBlockStatus(vote.BlockHash)
+	if status != blockchain.BLOCK_VALID && status != blockchain.BLOCK_VOTED {
+		return
+	}
+	if !dbft.Locks.Lock(prepared) {
+		return
+	}
+	dbft.SendCommit(vote.BlockHash, vote.BlockHeight, vote.View)
+}
 
+func (dbft DbftConsensus) SendCommit(blockHash []byte, height int64, view int) {
+	if !dbft.VoteResults.SetBroadcasted(fmt.Sprintf("commit_%d_%d", height, view)) {
+		return
+	}
+	dbft.sendVote(blockHash, height, view, blockchain.VOTE_PHASE_COMMIT)
+}
+
+func (dbft DbftConsensus) sendVote(blockHash []byte, height int64, view int, phase int) {
 	// 生成vote对象
 	vote := &blockchain.PeerBlockVote{
 		Vote: blockchain.BlockVoteDetail{
 			BlockchainId: dbft.Blockchain.ChainId,
-			BlockHash:    header.CaculateHash(),
-			BlockHeight:  header.Height,
+			BlockHash:    blockHash,
+			BlockHeight:  height,
 			VoteResult:   true,
+			Phase:        phase,
+			View:         view,
 		},
-		Peer: conf.EKTConfig.Node,
+		Peer: dbft.Node,
 	}
 
 	// 签名
-	err := vote.Sign(conf.EKTConfig.GetPrivateKey())
+	err := vote.Sign(dbft.PrivateKey)
 	if err != nil {
 		log.Crit("Sign vote failed, recorded. %v", err)
 		return
@@ -153,7 +284,7 @@ func (dbft DbftConsensus) delegateRun() {
 	//要求有半数以上节点存活才可以进行打包区块
 	moreThanHalf := false
 	for !moreThanHalf {
-		if AliveDelegatePeerCount(param.MainChainDelegateNode, false) <= len(param.MainChainDelegateNode)/2 {
+		if peers := dbft.GetRound().Peers; AliveDelegatePeerCount(peers, false) <= len(peers)/2 {
 			log.Info("Alive node is less than half, waiting for other delegate node restart.")
 			time.Sleep(3 * time.Second)
 		} else {
@@ -164,55 +295,150 @@ func (dbft DbftConsensus) delegateRun() {
 	// 每1/4个interval检测一次是否有漏块，如果发生漏块且当前节点可以出块，则进入打包流程
 	interval := blockchain.BackboneBlockInterval / 4
 	for {
-		// 判断是否是当前节点打包区块
-		if dbft.IsMyTurn() {
-			log.Info("It is my turn")
-			dbft.Client.SendHeartbeat()
-			dbft.Pack()
-		} else {
-			log.Info("It is not my turn.")
-		}
-
-		time.Sleep(interval)
+		dbft.Tick()
+		dbft.Clock.Sleep(interval)
 	}
 }
 
-func (dbft DbftConsensus) ReceiveHeartbeat(heartbeat types.Heartbeat) {
-	now := time.Now().UnixNano() / 1e6
-	if dbft.ValidatePackRight(now, dbft.Blockchain.LastHeader().Timestamp, heartbeat.Node) {
-		dbft.Round.SetTime(now)
+// 委托人线程的一次检测,轮到当前节点时打包,出块节点超时时发送跳过消息
+func (dbft DbftConsensus) Tick() {
+	// 判断是否是当前节点打包区块
+	if last, exist := dbft.Liveness.Get(dbft.Node.Account); !exist || dbft.now()-last.Timestamp >= int64(blockchain.BackboneBlockInterval)/1e6 {
+		dbft.SendHeartbeat()
+	}
+	if dbft.IsMyTurn() {
+		log.Info("It is my turn")
+		dbft.Pack()
+	} else if dbft.IsTimeout() {
+		log.Info("Packer timeout, sending skip vote.")
+		dbft.SendSkip()
+	} else {
+		log.Info("It is not my turn.")
 	}
 }
 
-func (dbft DbftConsensus) ValidatePackRight(packTime, lastBlockTime int64, node types.Peer) bool {
-	round := dbft.GetRound()
-	if lastBlockTime == 0 {
-		return round.Peers[0].Equal(node)
+// 出块顺序只由Round决定,心跳不再影响出块节点的判断
+// 签名并广播本节点的心跳,包含当前的高度和最新区块的hash
+func (dbft DbftConsensus) SendHeartbeat() {
+	last := dbft.Blockchain.LastHeader()
+	heartbeat := types.NewHeartbeat(dbft.Node, last.Height, last.CaculateHash(), dbft.now())
+	if err := heartbeat.Sign(dbft.PrivateKey); err != nil {
+		log.Crit("Sign heartbeat failed. %v", err)
+		return
 	}
-	intervalInFact, interval := int(packTime-lastBlockTime), int(blockchain.BackboneBlockInterval/1e6)
+	dbft.Liveness.Update(*heartbeat, dbft.now())
+	dbft.Client.SendHeartbeat(*heartbeat)
+}
 
-	// n表示距离上次打包的间隔
-	n := int(intervalInFact) / int(interval)
-	remainder := int(intervalInFact) % int(interval)
-	if n == 0 {
-		if remainder < int(interval)*3/2 {
-			return round.Peers[(round.CurrentIndex+1)%round.Len()].Equal(node)
-		} else {
-			return false
-		}
+// 记录委托人的心跳,签名错误、不是当前委托人、过期或者重放的心跳返回false
+func (dbft DbftConsensus) ReceiveHeartbeat(heartbeat types.Heartbeat) bool {
+	if !heartbeat.Validate() || dbft.GetRound().IndexOf(heartbeat.Node.Account) < 0 {
+		return false
+	}
+	now := dbft.now()
+	if heartbeat.Timestamp < now-int64(HeartbeatTimeout)/1e6 || heartbeat.Timestamp > now+int64(MaxClockDrift)/1e6 {
+		log.Debug("Stale heartbeat from %s.", heartbeat.Node.Account)
+		return false
 	}
-	n++
-	return round.Peers[(round.CurrentIndex+n)%round.Len()].Equal(node)
+	if !dbft.Liveness.Update(heartbeat, now) {
+		log.Debug("Replayed heartbeat from %s.", heartbeat.Node.Account)
+		return false
+	}
+	return true
+}
+
+// 当前委托人的存活状态
+func (dbft DbftConsensus) LivenessTable() []types.Liveness {
+	return dbft.Liveness.Records(dbft.GetRound().Peers, dbft.now(), int64(HeartbeatTimeout)/1e6)
+}
+
+// 校验node是否是当前Round中应该出块的节点
+func (dbft DbftConsensus) ValidatePackRight(node types.Peer) bool {
+	round := dbft.GetRound()
+	return round.Packer().Equal(node)
 }
 
 // 用于委托人线程判断当前节点是否有打包权限
 func (dbft DbftConsensus) IsMyTurn() bool {
-	now := time.Now().UnixNano() / 1e6
-	if now-dbft.GetRound().GetTime() > (int64(blockchain.BackboneBlockInterval)/1e6)*4/3 {
-		lastPackTime := dbft.Blockchain.LastHeader().Timestamp
-		return dbft.ValidatePackRight(now, lastPackTime, conf.EKTConfig.Node)
-	} else {
-		return false
+	return dbft.ValidatePackRight(dbft.Node)
+}
+
+// 当前出块节点是否超时未出块,本地时间只用来判断是否发送跳过消息,不参与出块节点的计算
+func (dbft DbftConsensus) IsTimeout() bool {
+	return dbft.now()-dbft.GetRound().GetTime() > int64(SkipTimeout)/1e6
+}
+
+// 签名并广播跳过当前出块节点的消息,同一个高度和view只签名一次
+// 已经签名过的消息重新广播,网络分区恢复之后其他节点仍然可以收集到足够的跳过消息
+// 消息中带上当前锁定的prepare证书,其他委托人可以据此解除更低view的锁定并commit这个区块
+// 锁定之后已经发送过的commit投票也重新广播,分区期间丢失的commit投票在恢复之后仍然可以组成voteResult
+func (dbft DbftConsensus) SendSkip() {
+	round := dbft.GetRound()
+	height := dbft.Blockchain.GetLastHeight() + 1
+	if locked := dbft.Locks.Get(height); len(locked) > 0 {
+		dbft.sendVote(locked[0].Vote.BlockHash, height, locked[0].Vote.View, blockchain.VOTE_PHASE_COMMIT)
+	}
+	vote := &blockchain.PeerSkipVote{
+		Skip: blockchain.SkipDetail{
+			BlockchainId: dbft.Blockchain.ChainId,
+			BlockHeight:  height,
+			View:         round.View,
+		},
+		Peer: dbft.Node,
+	}
+	for _, _vote := range dbft.SkipVotes.GetSkipVotes(vote.Skip.BlockHeight, vote.Skip.View) {
+		if _vote.Peer.Equal(vote.Peer) {
+			_vote.Prepared = dbft.Locks.Get(height)
+			dbft.Client.SendSkipVote(_vote)
+			return
+		}
+	}
+
+	err := vote.Sign(dbft.PrivateKey)
+	if err != nil {
+		log.Crit("Sign skip vote failed, recorded. %v", err)
+		return
+	}
+	dbft.SkipFromPeer(*vote)
+	vote.Prepared = dbft.Locks.Get(height)
+	dbft.Client.SendSkipVote(*vote)
+}
+
+// 记录其他委托人节点发送的跳过消息,超过2/3的委托人同意之后跳过当前出块节点
+func (dbft DbftConsensus) SkipFromPeer(vote blockchain.PeerSkipVote) {
+	round := dbft.GetRound()
+	if vote.Skip.BlockchainId != dbft.Blockchain.ChainId || round.IndexOf(vote.Peer.Account) < 0 {
+		return
+	}
+	if vote.Skip.BlockHeight != dbft.Blockchain.GetLastHeight()+1 {
+		return
+	}
+	if prepared := vote.Prepared; len(prepared) > 0 && prepared[0].Vote.BlockHeight == vote.Skip.BlockHeight &&
+		prepared.ValidatePrepared(round.Peers) {
+		dbft.CommitPrepared(prepared)
+	}
+	if vote.Skip.View < round.View {
+		return
+	}
+	vote.Prepared = nil
+	dbft.SkipVotes.Insert(vote)
+
+	// 可能已经提前收到了后续view的跳过消息,依次处理
+	skipped := false
+	for {
+		round = dbft.GetRound()
+		votes := dbft.SkipVotes.GetSkipVotes(vote.Skip.BlockHeight, round.View)
+		if len(votes) < util.MoreThanTwoThirds(round.Len()) || !dbft.Round.Skip(round.View) {
+			break
+		}
+		log.Info("Skipped packer %s at height %d.", round.Packer().Account, vote.Skip.BlockHeight)
+		skipped = true
+	}
+	if skipped {
+		dbft.Round.SetTime(dbft.now())
+		if dbft.IsMyTurn() {
+			dbft.Async(dbft.Pack)
+		}
 	}
 }
 
@@ -279,8 +505,8 @@ func (dbft DbftConsensus) Pack() {
 	lastHeader := dbft.Blockchain.LastHeader()
 	dbft.Locker.Lock()
 	defer dbft.Locker.Unlock()
-	if dbft.BlockManager.CheckHeightInterval(lastHeader.Height+1, int64(blockchain.BackboneBlockInterval)) {
-		dbft.BlockManager.SetBlockStatusByHeight(lastHeader.Height+1, time.Now().UnixNano()/1e6)
+	if dbft.BlockManager.CheckHeightInterval(lastHeader.Height+1, int64(blockchain.BackboneBlockInterval), dbft.now()) {
+		dbft.BlockManager.SetBlockStatusByHeight(lastHeader.Height+1, dbft.now())
 	} else {
 		return
 	}
@@ -288,7 +514,24 @@ func (dbft DbftConsensus) Pack() {
 	clog := ctxlog.NewContextLog("pack block")
 	defer clog.Finish()
 
-	block := blockchain.CreateBlock(lastHeader, conf.EKTConfig.Node)
+	// 同一个高度只签名一个区块,否则会被其他委托人作为作恶证据
+	// 签名的区块仍然在当前链之上时重新广播,回滚之后父区块已经被丢弃的不再广播,等待其他委托人在这个高度出块
+	if block := dbft.BlockManager.GetPackedBlock(lastHeader.Height + 1); block != nil &&
+		bytes.Equal(block.GetHeader().PreviousHash, lastHeader.CaculateHash()) {
+		dbft.Client.BroadcastBlock(*block)
+		clog.Log("rebroadcast", block)
+		return
+	}
+	if dbft.BlockManager.IsSigned(lastHeader.Height + 1) {
+		clog.Log("signed", lastHeader.Height+1)
+		return
+	}
+
+	block := blockchain.CreateBlock(lastHeader, dbft.Node)
+	block.GetHeader().Timestamp = dbft.now()
+	for _, evidence := range encapdb.GetPendingEvidences(dbft.Blockchain.ChainId, blockchain.MAX_EVIDENCES_PER_BLOCK) {
+		block.NewEvidence(evidence)
+	}
 	dbft.Blockchain.PackTransaction(clog, block)
 
 	// 增加打包信息
@@ -298,9 +541,10 @@ func (dbft DbftConsensus) Pack() {
 	dbft.BlockManager.SetBlockStatusByHeight(block.GetHeader().Height, block.GetHeader().Timestamp)
 
 	// 签名
-	if err := block.Sign(conf.EKTConfig.PrivateKey); err != nil {
+	if err := block.Sign(dbft.PrivateKey); err != nil {
 		log.Crit("Sign block failed. %v", err)
 	} else {
+		dbft.BlockManager.SetPackedBlock(block.GetHeader().Height, block)
 		// 广播
 		dbft.Client.BroadcastBlock(*block)
 		clog.Log("block", block)
@@ -319,6 +563,14 @@ func (dbft DbftConsensus) RecoverFromDB() {
 		dbft.SaveBlock(&block, nil)
 	}
 	dbft.Blockchain.SetLastHeader(*header)
+	dbft.updateDelegates(*header)
+	if dbft.FinalizedHeight() < 0 {
+		encapdb.SetFinalizedHeight(dbft.Blockchain.ChainId, encapdb.RecoverFinalizedHeight(dbft.Blockchain.ChainId, header.Height))
+	}
+	if block := encapdb.GetBlockByHeight(dbft.Blockchain.ChainId, header.Height); block != nil {
+		dbft.Round.UpdateIndex(block.Miner.Account)
+	}
+	dbft.Round.SetTime(dbft.now())
 	log.Info("Recovered from local database.")
 }
 
@@ -339,7 +591,7 @@ func AliveDelegatePeerCount(peers types.Peers, print bool) int {
 // 根据height同步区块
 func (dbft DbftConsensus) SyncHeight(height int64) bool {
 	log.Info("Synchronizing block at height %d \n", height)
-	if dbft.Blockchain.GetLastHeight() >= height {
+	if dbft.Blockchain.GetLastHeight() >= height || height <= dbft.FinalizedHeight() {
 		return true
 	}
 	block := dbft.Client.GetBlockByHeight(height)
@@ -350,28 +602,158 @@ func (dbft DbftConsensus) SyncHeight(height int64) bool {
 	if header == nil || header.Height != height {
 		return false
 	} else {
+		// 其他节点的区块和本地最新的区块不连续,说明本地链已经分叉
+		last := dbft.Blockchain.LastHeader()
+		if !bytes.Equal(header.PreviousHash, last.CaculateHash()) {
+			log.Info("Block at height %d does not follow local chain, reorganizing.", height)
+			return dbft.Reorganize()
+		}
 		votes := dbft.Client.GetVotesByBlockHash(hex.EncodeToString(header.CaculateHash()))
-		if votes == nil || !votes.Validate() {
+		if votes == nil || !dbft.ValidateVotes(votes) {
 			return false
 		}
-		transactions := block.GetTransactions()
-		receipts := block.GetTxReceipts()
-		last := dbft.Blockchain.LastHeader()
-		if last.ValidateBlockStat(*header, transactions, receipts) {
-			dbft.SaveBlock(block, votes)
-		}
+		dbft.saveSyncedBlock(block, votes)
 	}
 	return false
 }
 
+// 校验从其他节点同步的区块,成功之后写入链中
+func (dbft DbftConsensus) saveSyncedBlock(block *blockchain.Block, votes blockchain.Votes) bool {
+	header := block.GetHeader()
+	transactions := block.GetTransactions()
+	receipts := block.GetTxReceipts()
+	last := dbft.Blockchain.LastHeader()
+	if dbft.ValidateEvidences(block.Evidences) && last.ValidateBlockStat(dbft.Blockchain.ChainId, *header, transactions, receipts, block.Evidences) {
+		dbft.SaveBlock(block, votes)
+		dbft.finalizeParent(*header)
+		return true
+	}
+	return false
+}
+
+/*
+*fork choice:本地链和其他委托人的链分叉时,有超过2/3委托人commit投票的分支胜出
+*从本地最高高度向前查找和其他节点相同的公共祖先,不会越过不可逆高度
+*祖先之后的第一个区块有合法的voteResult时回滚到公共祖先,然后写入这个区块,后续区块由同步继续完成
+ */
+func (dbft DbftConsensus) Reorganize() bool {
+	chainId := dbft.Blockchain.ChainId
+	last, finalized := dbft.Blockchain.GetLastHeight(), dbft.FinalizedHeight()
+
+	var ancestor *blockchain.Header
+	for height := last; height >= 0 && height >= finalized; height-- {
+		local, remote := encapdb.GetHeaderByHeight(chainId, height), dbft.Client.GetHeaderByHeight(height)
+		if local == nil || remote == nil {
+			return false
+		}
+		if bytes.Equal(local.CaculateHash(), remote.CaculateHash()) {
+			ancestor = local
+			break
+		}
+	}
+	if ancestor == nil {
+		log.Crit("Can not find common ancestor above finalized height %d.", finalized)
+		return false
+	}
+
+	block := dbft.Client.GetBlockByHeight(ancestor.Height + 1)
+	if block == nil || block.GetHeader() == nil || !bytes.Equal(block.GetHeader().PreviousHash, ancestor.CaculateHash()) {
+		return false
+	}
+	votes := dbft.Client.GetVotesByBlockHash(hex.EncodeToString(block.Hash))
+	if votes == nil || !votes.Validate(DelegatesOf(*ancestor)) {
+		log.Info("Canonical block at height %d has no valid votes.", ancestor.Height+1)
+		return false
+	}
+	if local := encapdb.GetBlockByHeight(chainId, ancestor.Height+1); local != nil && bytes.Equal(local.Hash, block.Hash) {
+		return false
+	}
+
+	if !dbft.Rollback(ancestor.Height) {
+		return false
+	}
+	return dbft.saveSyncedBlock(block, votes)
+}
+
+// 回滚到height,height之后的区块中的交易重新放回交易池,区块中的作恶证据重新等待打包
+// 不能回滚到不可逆高度之前,也不能回滚到状态已经被清理的高度
+func (dbft DbftConsensus) Rollback(height int64) bool {
+	chainId := dbft.Blockchain.ChainId
+	last := dbft.Blockchain.GetLastHeight()
+	if height >= last {
+		return true
+	}
+	if !dbft.CanRollback(height+1) || height <= encapdb.GetPrunedHeight(chainId) {
+		log.Error("Refused to rollback to height %d, finalized height is %d.", height, dbft.FinalizedHeight())
+		return false
+	}
+	ancestor := encapdb.GetHeaderByHeight(chainId, height)
+	if ancestor == nil {
+		return false
+	}
+
+	orphaned := make([]userevent.Transaction, 0)
+	for h := height + 1; h <= last; h++ {
+		if block := encapdb.GetBlockByHeight(chainId, h); block != nil {
+			txs := block.GetTransactions()
+			for i := range txs {
+				encapdb.DeleteTxLocation(chainId, txs[i].TransactionId())
+			}
+			encapdb.DeleteHistory(chainId, blockchain.HistoryEntries(h, txs, block.GetTxReceipts()))
+			for _, evidence := range block.Evidences {
+				encapdb.DeleteEvidenceIncluded(chainId, evidence.Key())
+			}
+			dbft.BlockManager.Remove(block.Hash)
+			orphaned = append(orphaned, txs...)
+		}
+		encapdb.DeleteHeight(chainId, h)
+	}
+	encapdb.SetLastHeader(chainId, *ancestor)
+	dbft.Blockchain.SetLastHeader(*ancestor)
+	dbft.BlockManager.Reset(height)
+	dbft.updateDelegates(*ancestor)
+	if block := encapdb.GetBlockByHeight(chainId, height); block != nil {
+		dbft.Round.UpdateIndex(block.Miner.Account)
+	}
+	dbft.Round.SetTime(dbft.now())
+
+	requeued := dbft.Blockchain.Requeue(orphaned)
+	log.Warn("Rolled back from height %d to %d, requeued %d of %d orphaned transactions.", last, height, requeued, len(orphaned))
+	return true
+}
+
 // 从其他委托人节点发过来的区块的投票进行记录
+// prepare投票超过2/3之后发送commit投票,commit投票超过2/3之后将voteResult发送给其他节点
 func (dbft DbftConsensus) VoteFromPeer(vote blockchain.PeerBlockVote) {
+	round := dbft.GetRound()
+	if round.IndexOf(vote.Peer.Account) < 0 || vote.Vote.BlockchainId != dbft.Blockchain.ChainId {
+		return
+	}
+	if evidence := dbft.Detector.CheckVote(vote); evidence != nil {
+		dbft.SaveEvidence(*evidence)
+	}
 	dbft.VoteResults.Insert(vote)
 
-	if dbft.VoteResults.Number(vote.Vote.BlockHash) > len(dbft.GetRound().Peers)/2 {
-		log.Info("BlockVoteDetail number more than half node, sending vote result to other nodes.")
-		votes := dbft.VoteResults.GetVoteResults(hex.EncodeToString(vote.Vote.BlockHash))
-		dbft.Client.SendVoteResult(votes)
+	quorum := util.MoreThanTwoThirds(round.Len())
+	hash := vote.Vote.BlockHash
+	switch vote.Vote.Phase {
+	case blockchain.VOTE_PHASE_PREPARE:
+		// 只有本节点校验过的区块才发送commit投票
+		status := dbft.BlockManager.GetBlockStatus(hash)
+		if status != blockchain.BLOCK_VALID && status != blockchain.BLOCK_VOTED {
+			return
+		}
+		if prepared := dbft.VoteResults.GetPrepared(hex.EncodeToString(hash), vote.Vote.View); len(prepared) >= quorum {
+			log.Info("Prepare votes more than 2/3 node, sending commit vote.")
+			dbft.CommitPrepared(prepared)
+		}
+	case blockchain.VOTE_PHASE_COMMIT:
+		if dbft.VoteResults.Number(blockchain.VOTE_PHASE_COMMIT, hash) >= quorum &&
+			dbft.VoteResults.SetBroadcasted(hex.EncodeToString(hash)) {
+			log.Info("Commit votes more than 2/3 node, sending vote result to other nodes.")
+			votes := dbft.VoteResults.GetVoteResults(hex.EncodeToString(hash))
+			dbft.Client.SendVoteResult(votes)
+		}
 	}
 }
 
@@ -385,7 +767,7 @@ func (dbft DbftConsensus) RecieveVoteResult(votes blockchain.Votes) bool {
 	status := dbft.BlockManager.GetBlockStatus(votes[0].Vote.BlockHash)
 
 	// 已经写入到链中
-	if status == blockchain.BLOCK_SAVED {
+	if status >= blockchain.BLOCK_SAVED {
 		return true
 	}
 
@@ -395,43 +777,133 @@ func (dbft DbftConsensus) RecieveVoteResult(votes blockchain.Votes) bool {
 		log.Crit("Invalid block and votes, block.hash = %s", hex.EncodeToString(votes[0].Vote.BlockHash))
 	}
 
-	// 区块已经校验但未写入链中
+	// 区块已经校验但未写入链中,本地已经写入了同一个高度的其他区块时需要先回滚
 	if status == blockchain.BLOCK_VALID || status == blockchain.BLOCK_VOTED {
-		block := dbft.BlockManager.GetBlock(votes[0].Vote.BlockHash)
-		dbft.SaveBlock(block, votes)
-		round := dbft.GetRound()
-		if round.Peers[(round.CurrentIndex+1)%round.Len()].Equal(conf.EKTConfig.Node) {
-			dbft.Pack()
+		block, last := dbft.BlockManager.GetBlock(votes[0].Vote.BlockHash), dbft.Blockchain.LastHeader()
+		if bytes.Equal(block.GetHeader().PreviousHash, last.CaculateHash()) {
+			dbft.SaveBlock(block, votes)
+			dbft.finalizeParent(*block.GetHeader())
+			if dbft.IsMyTurn() {
+				dbft.Pack()
+			}
+			return true
 		}
-		return true
 	}
 
+	// 已经写入了同一个高度的其他区块,有合法投票的分支胜出
+	if votes[0].Vote.BlockHeight <= dbft.Blockchain.GetLastHeight() {
+		dbft.Async(func() { dbft.Reorganize() })
+	}
 	return false
 }
 
+// 返回已经不可逆的最高高度,这个高度及之前的区块不能再被同步覆盖或者回滚
+func (dbft DbftConsensus) FinalizedHeight() int64 {
+	return encapdb.GetFinalizedHeight(dbft.Blockchain.ChainId)
+}
+
+// 高于不可逆高度的区块才可以回滚
+func (dbft DbftConsensus) CanRollback(height int64) bool {
+	return height > dbft.FinalizedHeight()
+}
+
 func (dbft DbftConsensus) SaveBlock(block *blockchain.Block, votes blockchain.Votes) {
 	header := *block.GetHeader()
+	if header.Height <= dbft.FinalizedHeight() {
+		log.Error("Refused to overwrite finalized height %d, block.hash = %s", header.Height, hex.EncodeToString(block.Hash))
+		return
+	}
+	// epoch高度的区块写入之后,下一个区块开始使用新的委托人集合
+	if blockchain.IsEpochHeight(header.Height) {
+		dbft.updateDelegates(header)
+	}
 	dbft.Round.UpdateIndex(block.Miner.Account)
+	dbft.Round.SetTime(dbft.now())
+	dbft.SkipVotes.Clear(header.Height)
+	dbft.Locks.Clear(header.Height)
+	dbft.Detector.Clear(header.Height)
+	dbft.BlockManager.ClearPackedBlocks(header.Height)
+	for _, evidence := range block.Evidences {
+		encapdb.SetEvidence(dbft.Blockchain.ChainId, evidence)
+		encapdb.SetEvidenceIncluded(dbft.Blockchain.ChainId, evidence.Key(), header.Height)
+	}
+	dbft.saveTxIndex(block)
 	encapdb.SetVoteResults(dbft.Blockchain.ChainId, hex.EncodeToString(block.Hash), votes)
 	encapdb.SetBlockByHeight(dbft.Blockchain.ChainId, header.Height, *block)
 	encapdb.SetHeaderByHeight(dbft.Blockchain.ChainId, header.Height, header)
 	encapdb.SetLastHeader(dbft.Blockchain.ChainId, header)
 	dbft.Blockchain.SetLastHeader(header)
 	dbft.Blockchain.NotifyPool(block.GetTransactions())
+	dbft.BlockManager.SetBlockStatus(block.Hash, blockchain.BLOCK_SAVED)
+}
+
+/*
+*有合法voteResult的区块写入链中之后,如果父区块也保存了voteResult,父区块不可逆
+*一个区块的commit投票超过2/3之后,同一个高度在更高的view仍然可能有其他区块得到commit投票
+*超过2/3的委托人在这个区块之上commit了下一个区块之后,这个区块才不会再被回滚
+ */
+func (dbft DbftConsensus) finalizeParent(header blockchain.Header) {
+	chainId := dbft.Blockchain.ChainId
+	saved, parent := encapdb.GetHeaderByHeight(chainId, header.Height), encapdb.GetHeaderByHeight(chainId, header.Height-1)
+	if saved == nil || parent == nil || !bytes.Equal(saved.CaculateHash(), header.CaculateHash()) ||
+		!bytes.Equal(parent.CaculateHash(), header.PreviousHash) {
+		return
+	}
+	if parent.Height > 0 && len(encapdb.GetVoteResults(chainId, hex.EncodeToString(header.PreviousHash))) == 0 {
+		return
+	}
+	encapdb.SetFinalizedHeight(chainId, parent.Height)
+	dbft.BlockManager.SetBlockStatus(header.PreviousHash, blockchain.BLOCK_FINALIZED)
+	dbft.BlockManager.ClearSignedHeights(parent.Height)
+}
+
+// 记录区块中每个交易的高度、位置和执行结果,以及相关地址的交易记录
+func (dbft DbftConsensus) saveTxIndex(block *blockchain.Block) {
+	txs, receipts := block.GetTransactions(), block.GetTxReceipts()
+	if len(txs) != len(receipts) {
+		log.Error("Transactions and receipts mismatch, block.hash = %s", hex.EncodeToString(block.Hash))
+		return
+	}
+	for i := range txs {
+		encapdb.SetTxLocation(dbft.Blockchain.ChainId, blockchain.TxLocation{
+			TxId:      txs[i].TransactionId(),
+			BlockHash: block.Hash,
+			Height:    block.GetHeader().Height,
+			Index:     i,
+			Receipt:   receipts[i],
+		})
+	}
+	encapdb.AddHistory(dbft.Blockchain.ChainId, blockchain.HistoryEntries(block.GetHeader().Height, txs, receipts))
 }
 
 func (dbft DbftConsensus) SaveHeader(header blockchain.Header) {
 	db.GetDBInst().Set(header.CaculateHash(), header.Bytes())
 }
 
-// 校验voteResults
-func (dbft DbftConsensus) ValidateVotes(votes blockchain.Votes) bool {
-	if !votes.Validate() {
-		return false
+// 保存新发现的作恶证据,等待打包到区块中
+func (dbft DbftConsensus) SaveEvidence(evidence blockchain.Evidence) {
+	if encapdb.SetEvidence(dbft.Blockchain.ChainId, evidence) {
+		log.Warn("Found equivocation of %s at height %d, type = %d.", evidence.Offender.Account, evidence.Height, evidence.Type)
 	}
+}
 
-	if votes.Len() <= dbft.GetRound().Len()/2 {
-		return false
+// 区块中的作恶证据不能是已经被打包过的,同一个委托人在同一个高度的同一类作恶只能打包一次
+func (dbft DbftConsensus) ValidateEvidences(evidences blockchain.Evidences) bool {
+	for i, evidence := range evidences {
+		key := evidence.Key()
+		if encapdb.GetEvidenceIncludedHeight(dbft.Blockchain.ChainId, key) >= 0 {
+			return false
+		}
+		for j := 0; j < i; j++ {
+			if evidences[j].Key() == key {
+				return false
+			}
+		}
 	}
 	return true
 }
+
+// 校验voteResults,需要超过2/3的委托人commit
+func (dbft DbftConsensus) ValidateVotes(votes blockchain.Votes) bool {
+	return votes.Validate(dbft.GetRound().Peers)
+}
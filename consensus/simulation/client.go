@@ -0,0 +1,184 @@
+package simulation
+
+import (
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/ctxlog"
+	"github.com/OpenOCC/OCC/encapdb"
+)
+
+/*
+*client是模拟网络中节点index使用的occclient.IClient
+*广播的消息经过Simulator.send按照延迟、丢包和分区投递,和api中对应接口的校验保持一致
+*查询请求同步读取其他节点的数据库,只会访问可以连通的节点
+*区块和查询结果按照BlockMessage序列化之后在接收方重新解析和校验,避免节点之间共享状态树
+ */
+type client struct {
+	sim   *Simulator
+	index int
+	peers []types.Peer
+}
+
+// 当前委托人集合中除了自己之外的节点,peers为空时使用所有节点
+func (client *client) targets(self bool) []int {
+	targets := make([]int, 0, len(client.sim.nodes))
+	for _, node := range client.sim.nodes {
+		if node.Index == client.index && !self {
+			continue
+		}
+		if client.peers == nil {
+			targets = append(targets, node.Index)
+			continue
+		}
+		for _, peer := range client.peers {
+			if peer.Equal(node.Peer) {
+				targets = append(targets, node.Index)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// 依次从可以连通的节点读取数据,read返回true时停止
+func (client *client) query(read func(node *Node) bool) {
+	for _, index := range client.targets(false) {
+		if !client.sim.reachable(client.index, index) {
+			continue
+		}
+		found := false
+		client.sim.within(index, func() { found = read(client.sim.nodes[index]) })
+		if found {
+			return
+		}
+	}
+}
+
+func (client *client) GetHeaderByHeight(height int64) *blockchain.Header {
+	var data []byte
+	client.query(func(node *Node) bool {
+		if header := encapdb.GetHeaderByHeight(CHAIN_ID, height); header != nil {
+			data = header.Bytes()
+		}
+		return data != nil
+	})
+	if data == nil {
+		return nil
+	}
+	return blockchain.FromBytes2Header(data)
+}
+
+func (client *client) GetBlockByHeight(height int64) *blockchain.Block {
+	var data []byte
+	client.query(func(node *Node) bool {
+		block := encapdb.GetBlockByHeight(CHAIN_ID, height)
+		if block == nil {
+			return false
+		}
+		if message := blockchain.NewBlockMessage(*block); message != nil {
+			data = message.Bytes()
+		}
+		return data != nil
+	})
+	if data == nil {
+		return nil
+	}
+	if message := blockchain.GetBlockMessageFromBytes(data); message != nil {
+		return message.ToBlock()
+	}
+	return nil
+}
+
+func (client *client) GetLastBlock(peer types.Peer) *blockchain.Header {
+	var data []byte
+	client.query(func(node *Node) bool {
+		if !node.Peer.Equal(peer) {
+			return false
+		}
+		if header := encapdb.GetLastHeader(CHAIN_ID); header != nil {
+			data = header.Bytes()
+		}
+		return true
+	})
+	if data == nil {
+		return nil
+	}
+	return blockchain.FromBytes2Header(data)
+}
+
+func (client *client) GetVotesByBlockHash(hash string) blockchain.Votes {
+	var votes blockchain.Votes
+	client.query(func(node *Node) bool {
+		votes = encapdb.GetVoteResults(CHAIN_ID, hash)
+		return len(votes) != 0
+	})
+	return votes
+}
+
+func (client *client) BroadcastBlock(block blockchain.Block) {
+	message := blockchain.NewBlockMessage(block)
+	if message == nil {
+		return
+	}
+	data := message.Bytes()
+	for _, index := range client.targets(true) {
+		node := client.sim.nodes[index]
+		client.sim.send(client.index, index, func() {
+			message := blockchain.GetBlockMessageFromBytes(data)
+			if message == nil {
+				return
+			}
+			_block := message.ToBlock()
+			if _block == nil || node.Dbft.Blockchain.GetLastHeight()+1 != _block.GetHeader().Height {
+				return
+			}
+			clog := ctxlog.NewContextLog("blockFromPeer")
+			defer clog.Finish()
+			node.Dbft.BlockFromPeer(clog, *_block)
+		})
+	}
+}
+
+func (client *client) SendVote(vote blockchain.PeerBlockVote) {
+	for _, index := range client.targets(true) {
+		node := client.sim.nodes[index]
+		client.sim.send(client.index, index, func() {
+			if vote.Validate() {
+				node.Dbft.VoteFromPeer(vote)
+			}
+		})
+	}
+}
+
+func (client *client) SendVoteResult(votes blockchain.Votes) {
+	for _, index := range client.targets(true) {
+		node := client.sim.nodes[index]
+		client.sim.send(client.index, index, func() {
+			node.Dbft.RecieveVoteResult(votes)
+		})
+	}
+}
+
+func (client *client) SendSkipVote(vote blockchain.PeerSkipVote) {
+	for _, index := range client.targets(true) {
+		node := client.sim.nodes[index]
+		client.sim.send(client.index, index, func() {
+			if vote.Validate() {
+				node.Dbft.SkipFromPeer(vote)
+			}
+		})
+	}
+}
+
+func (client *client) SendHeartbeat(heartbeat types.Heartbeat) {
+	for _, index := range client.targets(false) {
+		node := client.sim.nodes[index]
+		client.sim.send(client.index, index, func() {
+			node.Dbft.ReceiveHeartbeat(heartbeat)
+		})
+	}
+}
+
+func (client *client) SetPeers(peers []types.Peer) {
+	client.peers = peers
+}
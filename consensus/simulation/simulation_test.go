@@ -0,0 +1,470 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/conf"
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/ctxlog"
+	"github.com/OpenOCC/OCC/encapdb"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func newSimulator(seed int64) *Simulator {
+	log.InitLog("/tmp/simulation_test.log")
+	return NewSimulator(Config{
+		Delegates: 4,
+		Latency:   50 * time.Millisecond,
+		Jitter:    100 * time.Millisecond,
+		Seed:      seed,
+	})
+}
+
+// 创世区块中的账户,私钥和委托人一样是确定性的
+func genesisAccounts(n int) ([]types.Account, [][]byte) {
+	accounts := make([]types.Account, 0, n)
+	privKeys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		privKey := crypto.Sha3_256([]byte(fmt.Sprintf("simulation_account_%d", i)))
+		pubKey, err := crypto.PubKey(privKey)
+		if err != nil {
+			panic(err)
+		}
+		account := types.CreateAccount(types.FromPubKeyToAddress(pubKey), 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+		privKeys = append(privKeys, privKey)
+	}
+	return accounts, privKeys
+}
+
+func signedTransaction(t *testing.T, privKey []byte, from, to types.Account, amount, nonce int64) *userevent.Transaction {
+	tx := userevent.NewTransaction(from.Address, to.Address, 0, amount, 1, nonce, "", "")
+	if err := userevent.SignTransaction(tx, CHAIN_ID, privKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func checkSafety(t *testing.T, sim *Simulator) {
+	if err := sim.CheckSafety(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulationProgress(t *testing.T) {
+	sim := newSimulator(1)
+	sim.Run(20 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	for i := range sim.Nodes() {
+		if sim.Height(i) < 5 {
+			t.Fatalf("node %d is at height %d after 20 intervals", i, sim.Height(i))
+		}
+		// 最新的区块之上还没有commit的子区块,所以不可逆高度比最新高度低1
+		if sim.FinalizedHeight(i) != sim.Height(i)-1 {
+			t.Fatalf("node %d finalized height %d at height %d", i, sim.FinalizedHeight(i), sim.Height(i))
+		}
+	}
+}
+
+func TestSimulationFinality(t *testing.T) {
+	sim := newSimulator(8)
+	sim.Run(10 * blockchain.BackboneBlockInterval)
+	node, miner := sim.Nodes()[0], sim.Nodes()[1]
+	finalized := sim.FinalizedHeight(0)
+	if finalized < 2 {
+		t.Fatalf("finalized height is %d after 10 intervals", finalized)
+	}
+
+	sim.within(node.Index, func() {
+		dbft, last := node.Dbft, node.Dbft.Blockchain.GetLastHeight()
+		if !dbft.CanRollback(last) || dbft.CanRollback(finalized) {
+			t.Fatalf("only blocks above finalized height %d can be rolled back, last height %d", finalized, last)
+		}
+		if dbft.Rollback(finalized-1) || dbft.Blockchain.GetLastHeight() != last {
+			t.Fatal("rollback below finalized height should be refused")
+		}
+
+		// 不可逆高度上的其他区块不能通过写入或者同步覆盖本地的区块
+		hash := sim.HeaderHash(node.Index, finalized)
+		parent := encapdb.GetHeaderByHeight(CHAIN_ID, finalized-1)
+		fork := blockchain.CreateBlock(*parent, miner.Peer)
+		fork.Finish()
+		fork.GetHeader().Timestamp = parent.Timestamp + 1
+		fork.Hash = fork.GetHeader().CaculateHash()
+		if err := fork.Sign(miner.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		dbft.SaveBlock(fork, nil)
+		if !dbft.SyncHeight(finalized) {
+			t.Fatal("sync below finalized height should be skipped")
+		}
+		if !bytes.Equal(sim.HeaderHash(node.Index, finalized), hash) || dbft.Blockchain.GetLastHeight() != last {
+			t.Fatal("finalized block should not be overwritten")
+		}
+	})
+	checkSafety(t, sim)
+}
+
+func TestSimulationCrashedPacker(t *testing.T) {
+	sim := newSimulator(2)
+	sim.Crash(1)
+	sim.Run(20 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	if sim.FinalizedHeight(0) < 5 {
+		t.Fatalf("finalized height is %d with one delegate down", sim.FinalizedHeight(0))
+	}
+
+	sim.Restart(1)
+	sim.Run(20 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	if sim.Height(1) < sim.Height(0)-1 {
+		t.Fatalf("restarted node is at height %d, others at %d", sim.Height(1), sim.Height(0))
+	}
+}
+
+func TestSimulationPartition(t *testing.T) {
+	sim := newSimulator(3)
+	sim.Run(5 * blockchain.BackboneBlockInterval)
+	sim.Partition([]int{0, 1}, []int{2, 3})
+	finalized := sim.FinalizedHeight(0)
+	for i := range sim.Nodes() {
+		if sim.FinalizedHeight(i) > finalized {
+			finalized = sim.FinalizedHeight(i)
+		}
+	}
+	sim.Run(20 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	for i := range sim.Nodes() {
+		// 分区之前已经发出的commit投票可能还会让一个区块不可逆
+		if sim.FinalizedHeight(i) > finalized+1 {
+			t.Fatalf("node %d finalized height %d without a quorum", i, sim.FinalizedHeight(i))
+		}
+	}
+
+	// 分区期间已经commit过的委托人在view change之后仍然可以commit新的区块
+	height := sim.Height(0)
+	for i := range sim.Nodes() {
+		if sim.Height(i) > height {
+			height = sim.Height(i)
+		}
+		if sim.FinalizedHeight(i) > finalized {
+			finalized = sim.FinalizedHeight(i)
+		}
+	}
+	sim.Heal()
+	sim.Run(20 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	for i := range sim.Nodes() {
+		if sim.Height(i) < height+5 || sim.FinalizedHeight(i) < finalized+5 {
+			t.Fatalf("node %d is at height %d, finalized %d after healing at height %d, finalized %d",
+				i, sim.Height(i), sim.FinalizedHeight(i), height, finalized)
+		}
+	}
+}
+
+func TestSimulationDeterministic(t *testing.T) {
+	run := func() [][]byte {
+		sim := newSimulator(4)
+		sim.config.Loss = 0.1
+		sim.Run(15 * blockchain.BackboneBlockInterval)
+		hashes := make([][]byte, 0)
+		for height := int64(0); height <= sim.Height(0); height++ {
+			hashes = append(hashes, sim.HeaderHash(0, height))
+		}
+		return hashes
+	}
+	first, second := run(), run()
+	if len(first) != len(second) {
+		t.Fatalf("runs reached different heights: %d, %d", len(first)-1, len(second)-1)
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("runs diverged at height %d", i)
+		}
+	}
+}
+
+func TestSimulationInvalidProposal(t *testing.T) {
+	sim := newSimulator(5)
+	sim.Run(2 * blockchain.BackboneBlockInterval)
+	node, miner := sim.Nodes()[0], sim.Nodes()[1]
+
+	sim.within(node.Index, func() {
+		last := node.Dbft.Blockchain.LastHeader()
+		propose := func(peer types.Peer, privKey []byte, update func(header *blockchain.Header)) blockchain.Block {
+			block := blockchain.CreateBlock(last, peer)
+			block.Finish()
+			block.GetHeader().Timestamp = last.Timestamp + 1
+			update(block.GetHeader())
+			block.Hash = block.GetHeader().CaculateHash()
+			if err := block.Sign(privKey); err != nil {
+				t.Fatal(err)
+			}
+			return *block
+		}
+		nothing := func(header *blockchain.Header) {}
+
+		pub, priv := crypto.GenerateKeyPair()
+		outsider := types.Peer{Account: hex.EncodeToString(types.FromPubKeyToAddress(pub))}
+		tampered := propose(miner.Peer, miner.PrivateKey, nothing)
+		tampered.GetHeader().TotalFee++
+
+		cases := []struct {
+			name   string
+			block  blockchain.Block
+			status int
+		}{
+			{"valid", propose(miner.Peer, miner.PrivateKey, nothing), blockchain.BLOCK_TO_BE_HANDLE},
+			{"hash", tampered, blockchain.BLOCK_ERROR_HASH},
+			{"sign", propose(miner.Peer, node.PrivateKey, nothing), blockchain.BLOCK_ERROR_SIGN},
+			{"miner", propose(outsider, priv, nothing), blockchain.BLOCK_ERROR_MINER},
+			{"old timestamp", propose(miner.Peer, miner.PrivateKey, func(header *blockchain.Header) {
+				header.Timestamp = last.Timestamp
+			}), blockchain.BLOCK_ERROR_PACK_TIME},
+			{"future timestamp", propose(miner.Peer, miner.PrivateKey, func(header *blockchain.Header) {
+				header.Timestamp = sim.Now().Add(time.Minute).UnixNano() / 1e6
+			}), blockchain.BLOCK_ERROR_PACK_TIME},
+			{"previous hash", propose(miner.Peer, miner.PrivateKey, func(header *blockchain.Header) {
+				header.PreviousHash = crypto.Sha3_256([]byte("fork"))
+			}), blockchain.BLOCK_ERROR_PREVIOUS_HASH},
+		}
+		for _, c := range cases {
+			if status := node.Dbft.ValidateProposal(c.block); status != c.status {
+				t.Errorf("%s: status %d, expected %d", c.name, status, c.status)
+			}
+		}
+	})
+}
+
+func TestSimulationForgedProposal(t *testing.T) {
+	sim := newSimulator(10)
+	sim.Run(2 * blockchain.BackboneBlockInterval)
+	node := sim.Nodes()[0]
+	var packer *Node
+	for _, n := range sim.Nodes() {
+		if n.Index != node.Index && node.Dbft.ValidatePackRight(n.Peer) {
+			packer = n
+		}
+	}
+	if packer == nil {
+		t.Fatal("another node should be the packer")
+	}
+
+	sim.within(node.Index, func() {
+		last := node.Dbft.Blockchain.LastHeader()
+		block := blockchain.CreateBlock(last, packer.Peer)
+		block.Finish()
+		block.GetHeader().Timestamp = sim.Now().UnixNano() / 1e6
+		block.Hash = block.GetHeader().CaculateHash()
+		forged := *block
+		if err := forged.Sign(node.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		// 同一个伪造签名的区块第二次收到时也不能通过校验
+		for i := 0; i < 2; i++ {
+			clog := ctxlog.NewContextLog("forged")
+			node.Dbft.BlockFromPeer(clog, forged)
+			clog.Finish()
+			if status := node.Dbft.BlockManager.GetBlockStatus(block.Hash); status != blockchain.BLOCK_ERROR_SIGN {
+				t.Fatalf("delivery %d: forged block status %d", i+1, status)
+			}
+		}
+
+		// 伪造签名不影响packer签名的同一个区块
+		if err := block.Sign(packer.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		clog := ctxlog.NewContextLog("signed")
+		node.Dbft.BlockFromPeer(clog, *block)
+		clog.Finish()
+		if status := node.Dbft.BlockManager.GetBlockStatus(block.Hash); status != blockchain.BLOCK_VALID && status != blockchain.BLOCK_VOTED {
+			t.Fatalf("signed block status %d", status)
+		}
+	})
+}
+
+func TestSimulationHeartbeat(t *testing.T) {
+	sim := newSimulator(6)
+	sim.Run(5 * blockchain.BackboneBlockInterval)
+	node := sim.Nodes()[0]
+	for _, record := range node.Dbft.LivenessTable() {
+		if !record.Alive {
+			t.Fatalf("delegate %s should be alive", record.Peer.Account)
+		}
+	}
+
+	last, _ := node.Dbft.Liveness.Get(sim.Nodes()[1].Peer.Account)
+	replayed := types.NewHeartbeat(last.Peer, last.Height, last.BlockHash, last.Timestamp)
+	if err := replayed.Sign(sim.Nodes()[1].PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if node.Dbft.ReceiveHeartbeat(*replayed) {
+		t.Fatal("replayed heartbeat should be rejected")
+	}
+
+	sim.Crash(2)
+	sim.Run(5 * blockchain.BackboneBlockInterval)
+	stale := types.NewHeartbeat(sim.Nodes()[2].Peer, 0, nil, last.Timestamp+1)
+	if err := stale.Sign(sim.Nodes()[2].PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if node.Dbft.ReceiveHeartbeat(*stale) {
+		t.Fatal("stale heartbeat should be rejected")
+	}
+	for _, record := range node.Dbft.LivenessTable() {
+		if record.Alive != !record.Peer.Equal(sim.Nodes()[2].Peer) {
+			t.Fatalf("delegate %s alive: %v", record.Peer.Account, record.Alive)
+		}
+	}
+}
+
+func TestSimulationTxLocation(t *testing.T) {
+	// 交易的默克尔证明需要HEADER_VERSION_TX_MERKLE
+	blockchain.SetVersionHeights(make([]int64, blockchain.HEADER_VERSION_LATEST+1))
+	defer blockchain.SetVersionHeights(nil)
+	accounts, privKeys := genesisAccounts(2)
+	conf.EKTConfig.GenesisBlockAccounts = accounts
+	defer func() { conf.EKTConfig.GenesisBlockAccounts = nil }()
+
+	sim := newSimulator(7)
+	transfer := signedTransaction(t, privKeys[0], accounts[0], accounts[1], 10, 1)
+	overdraft := signedTransaction(t, privKeys[1], accounts[1], accounts[0], 1000, 1)
+	sim.within(0, func() {
+		chain := sim.Nodes()[0].Dbft.Blockchain
+		if !chain.NewTransaction(transfer) || !chain.NewTransaction(overdraft) {
+			t.Fatal("transactions should enter the pool")
+		}
+	})
+	sim.Run(10 * blockchain.BackboneBlockInterval)
+
+	for _, node := range sim.Nodes() {
+		sim.within(node.Index, func() {
+			location := encapdb.GetTxLocation(CHAIN_ID, transfer.TransactionId())
+			if location == nil || !location.Receipt.Success || location.Height <= 0 {
+				t.Fatalf("node %d: unexpected location %v", node.Index, location)
+			}
+			block := encapdb.GetBlockByHeight(CHAIN_ID, location.Height)
+			if block == nil || !bytes.Equal(block.Hash, location.BlockHash) || location.Index != 0 {
+				t.Fatalf("node %d: location does not point to the transaction", node.Index)
+			}
+			if txs := block.GetTransactions(); len(txs) != 2 || txs[0].TransactionId() != transfer.TransactionId() {
+				t.Fatalf("node %d: block body should be stored locally, got %v", node.Index, txs)
+			}
+			proof := blockchain.ProveTx(blockchain.TxLeaves(block.GetTransactions(), block.GetTxReceipts()), location.Index)
+			if proof == nil || !proof.Verify(block.GetHeader().TxHash, *transfer, location.Receipt) {
+				t.Fatalf("node %d: transaction proof should verify against TxHash", node.Index)
+			}
+			failed := encapdb.GetTxLocation(CHAIN_ID, overdraft.TransactionId())
+			if failed == nil || failed.Receipt.Success || failed.Height != location.Height || failed.Index != 1 {
+				t.Fatalf("node %d: overdraft should be included as failed, got %v", node.Index, failed)
+			}
+			history, _ := encapdb.GetHistory(CHAIN_ID, hex.EncodeToString(accounts[1].Address), -1, 10)
+			if len(history) != 2 || history[0].TxId != transfer.TransactionId() || history[1].Success {
+				t.Fatalf("node %d: unexpected history %v", node.Index, history)
+			}
+		})
+	}
+}
+
+func TestSimulationFork(t *testing.T) {
+	accounts, privKeys := genesisAccounts(2)
+	conf.EKTConfig.GenesisBlockAccounts = accounts
+	defer func() { conf.EKTConfig.GenesisBlockAccounts = nil }()
+
+	sim := newSimulator(9)
+	sim.Run(5 * blockchain.BackboneBlockInterval)
+	node, offender := sim.Nodes()[0], sim.Nodes()[2]
+
+	// offender在同一个view对两个区块发送commit投票
+	votes := make(blockchain.Votes, 0, 2)
+	for _, hash := range [][]byte{crypto.Sha3_256([]byte("a")), crypto.Sha3_256([]byte("b"))} {
+		vote := blockchain.PeerBlockVote{
+			Vote: blockchain.BlockVoteDetail{BlockchainId: CHAIN_ID, BlockHash: hash, BlockHeight: 1, VoteResult: true, Phase: blockchain.VOTE_PHASE_COMMIT},
+			Peer: offender.Peer,
+		}
+		if err := vote.Sign(offender.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		votes = append(votes, vote)
+	}
+	evidence := blockchain.NewVoteEvidence(votes[0], votes[1])
+	transfer := signedTransaction(t, privKeys[0], accounts[0], accounts[1], 10, 1)
+
+	// 模拟节点0写入了一个没有超过2/3委托人commit的分支,其他节点在同一个高度commit了其他区块
+	var fork *blockchain.Block
+	sim.within(node.Index, func() {
+		dbft := node.Dbft
+		last := dbft.Blockchain.LastHeader()
+		if dbft.BlockManager.GetPackedBlock(last.Height+1) != nil {
+			t.Fatal("node should not have packed the next height yet")
+		}
+		if !dbft.Blockchain.NewTransaction(transfer) {
+			t.Fatal("transaction should enter the pool")
+		}
+		fork = blockchain.CreateBlock(last, node.Peer)
+		fork.GetHeader().Timestamp = last.Timestamp + 1
+		if !fork.NewEvidence(evidence) {
+			t.Fatal("evidence should be valid")
+		}
+		dbft.Blockchain.PackTransaction(ctxlog.NewContextLog("fork"), fork)
+		if err := fork.Sign(node.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+		dbft.BlockManager.SetPackedBlock(last.Height+1, fork)
+		dbft.SaveBlock(fork, nil)
+	})
+	height := fork.GetHeader().Height
+	if sim.Height(node.Index) != height || len(fork.GetTransactions()) != 1 {
+		t.Fatalf("fork block with the transaction should be saved at height %d", height)
+	}
+
+	sim.Run(15 * blockchain.BackboneBlockInterval)
+	checkSafety(t, sim)
+	if hash := sim.HeaderHash(node.Index, height); bytes.Equal(hash, fork.Hash) || !bytes.Equal(hash, sim.HeaderHash(1, height)) {
+		t.Fatal("node should switch to the committed branch")
+	}
+	if sim.Height(node.Index) < height+5 {
+		t.Fatalf("node is at height %d after rollback at %d", sim.Height(node.Index), height)
+	}
+	if node.Dbft.BlockManager.GetBlockStatus(fork.Hash) != -1 {
+		t.Fatal("orphaned block status should be cleared")
+	}
+	if node.Dbft.BlockManager.GetPackedBlock(height) != nil {
+		t.Fatal("packed block should be dropped after the height is saved")
+	}
+	for _, n := range sim.Nodes() {
+		n.Dbft.BlockManager.PackedBlocks.Range(func(key, value interface{}) bool {
+			if key.(int64) <= sim.Height(n.Index) {
+				t.Fatalf("node %d: packed block at saved height %d should be dropped", n.Index, key)
+			}
+			return true
+		})
+	}
+
+	// 孤块中的交易和作恶证据重新放回交易池,在新的分支中重新打包
+	included := int64(-1)
+	for _, n := range sim.Nodes() {
+		sim.within(n.Index, func() {
+			location := encapdb.GetTxLocation(CHAIN_ID, transfer.TransactionId())
+			if location == nil || location.Height <= height || bytes.Equal(location.BlockHash, fork.Hash) {
+				t.Fatalf("node %d: transaction should be replayed after height %d, got %v", n.Index, height, location)
+			}
+			if history, _ := encapdb.GetHistory(CHAIN_ID, hex.EncodeToString(accounts[1].Address), -1, 10); len(history) != 1 {
+				t.Fatalf("node %d: orphaned history should be removed, got %v", n.Index, history)
+			}
+			at := encapdb.GetEvidenceIncludedHeight(CHAIN_ID, evidence.Key())
+			if at <= height || (included >= 0 && at != included) {
+				t.Fatalf("node %d: evidence included at %d, expected the same height after %d", n.Index, at, height)
+			}
+			included = at
+		})
+	}
+}
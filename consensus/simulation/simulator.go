@@ -0,0 +1,279 @@
+package simulation
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/consensus"
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb"
+	"github.com/OpenOCC/OCC/param"
+	"github.com/OpenOCC/OCC/util"
+)
+
+const CHAIN_ID = 1
+
+type Config struct {
+	Delegates int
+	Latency   time.Duration // 每条消息的基础延迟
+	Jitter    time.Duration // 在基础延迟上随机增加的最大延迟
+	Loss      float64       // 消息丢失的概率,节点发给自己的消息不会丢失
+	Seed      int64
+}
+
+type Node struct {
+	Index      int
+	Peer       types.Peer
+	PrivateKey []byte
+	DB         db.IKVDatabase
+	Dbft       *consensus.DbftConsensus
+	crashed    bool
+}
+
+type event struct {
+	at       time.Time
+	seq      int64
+	node     int
+	fn       func()
+	periodic bool
+}
+
+type eventQueue []*event
+
+func (queue eventQueue) Len() int { return len(queue) }
+
+func (queue eventQueue) Less(i, j int) bool {
+	if !queue[i].at.Equal(queue[j].at) {
+		return queue[i].at.Before(queue[j].at)
+	}
+	return queue[i].seq < queue[j].seq
+}
+
+func (queue eventQueue) Swap(i, j int) { queue[i], queue[j] = queue[j], queue[i] }
+
+func (queue *eventQueue) Push(x interface{}) { *queue = append(*queue, x.(*event)) }
+
+func (queue *eventQueue) Pop() interface{} {
+	old := *queue
+	e := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return e
+}
+
+/*
+*Simulator在一个进程中运行多个委托人节点,所有的节点共享一个FakeClock
+*消息、定时任务和异步任务都放入同一个事件队列,按照时间和加入顺序在一个线程中依次执行
+*相同的Config和Seed每次执行的结果都相同
+*encapdb使用全局的db.EktDB,执行某个节点的事件之前切换到这个节点自己的数据库,所以同一时间只能运行一个Simulator
+ */
+type Simulator struct {
+	config    Config
+	clock     *util.FakeClock
+	rand      *rand.Rand
+	nodes     []*Node
+	queue     eventQueue
+	seq       int64
+	partition map[int]int
+}
+
+func NewSimulator(config Config) *Simulator {
+	sim := &Simulator{
+		config: config,
+		clock:  util.NewFakeClock(time.Unix(1500000000, 0)),
+		rand:   rand.New(rand.NewSource(config.Seed)),
+	}
+	peers := make(types.Peers, 0, config.Delegates)
+	for i := 0; i < config.Delegates; i++ {
+		privKey := crypto.Sha3_256([]byte(fmt.Sprintf("simulation_delegate_%d", i)))
+		pubKey, err := crypto.PubKey(privKey)
+		if err != nil {
+			panic(err)
+		}
+		peer := types.Peer{
+			Account: hex.EncodeToString(types.FromPubKeyToAddress(pubKey)),
+			Address: "127.0.0.1",
+			Port:    int32(20000 + i),
+		}
+		peers = append(peers, peer)
+		sim.nodes = append(sim.nodes, &Node{Index: i, Peer: peer, PrivateKey: privKey, DB: db.NewMemKVDatabase()})
+	}
+	param.MainChainDelegateNode = peers
+
+	for _, node := range sim.nodes {
+		sim.start(node)
+		i := node.Index
+		sim.every(i, blockchain.BackboneBlockInterval/4, func() { sim.nodes[i].Dbft.Tick() })
+		// 和delegateSync一样,同步成功之后继续同步下一个高度
+		sim.every(i, blockchain.BackboneBlockInterval, func() {
+			dbft := sim.nodes[i].Dbft
+			for {
+				height := dbft.Blockchain.GetLastHeight()
+				dbft.SyncHeight(height + 1)
+				if dbft.Blockchain.GetLastHeight() == height {
+					break
+				}
+			}
+		})
+	}
+	return sim
+}
+
+// 使用节点的数据库创建共识实例,重启的节点只保留数据库中的数据
+func (sim *Simulator) start(node *Node) {
+	chain := blockchain.NewBlockChain(CHAIN_ID)
+	chain.Clock = sim.clock
+	dbft := consensus.NewDbftConsensus(chain, &client{sim: sim, index: node.Index})
+	dbft.Node = node.Peer
+	dbft.PrivateKey = node.PrivateKey
+	dbft.Clock = sim.clock
+	dbft.Async = func(f func()) {
+		sim.schedule(0, node.Index, f)
+	}
+	node.Dbft = dbft
+	node.crashed = false
+	sim.within(node.Index, dbft.RecoverFromDB)
+}
+
+func (sim *Simulator) Nodes() []*Node {
+	return sim.nodes
+}
+
+func (sim *Simulator) Now() time.Time {
+	return sim.clock.Now()
+}
+
+func (sim *Simulator) push(delay time.Duration, index int, fn func(), periodic bool) {
+	sim.seq++
+	heap.Push(&sim.queue, &event{at: sim.clock.Now().Add(delay), seq: sim.seq, node: index, fn: fn, periodic: periodic})
+}
+
+// 在delay之后执行节点index的任务,节点宕机时任务被丢弃
+func (sim *Simulator) schedule(delay time.Duration, index int, fn func()) {
+	sim.push(delay, index, fn, false)
+}
+
+// 节点的定时任务,宕机期间不执行,重启之后继续执行
+func (sim *Simulator) every(index int, interval time.Duration, fn func()) {
+	var loop func()
+	loop = func() {
+		if !sim.nodes[index].crashed {
+			fn()
+		}
+		sim.push(interval, index, loop, true)
+	}
+	sim.push(interval, index, loop, true)
+}
+
+// 切换到节点index的数据库执行fn,执行完成之后恢复
+func (sim *Simulator) within(index int, fn func()) {
+	last := db.EktDB
+	db.EktDB = sim.nodes[index].DB
+	defer func() { db.EktDB = last }()
+	fn()
+}
+
+// 执行d时间内的所有事件
+func (sim *Simulator) Run(d time.Duration) {
+	end := sim.clock.Now().Add(d)
+	for sim.queue.Len() > 0 && !sim.queue[0].at.After(end) {
+		e := heap.Pop(&sim.queue).(*event)
+		sim.clock.Set(e.at)
+		if sim.nodes[e.node].crashed && !e.periodic {
+			continue
+		}
+		sim.within(e.node, e.fn)
+	}
+	sim.clock.Set(end)
+}
+
+func (sim *Simulator) Crash(index int) {
+	sim.nodes[index].crashed = true
+}
+
+func (sim *Simulator) Restart(index int) {
+	sim.start(sim.nodes[index])
+}
+
+// 把节点分成互相不能通信的组,没有出现在groups中的节点单独作为一组
+func (sim *Simulator) Partition(groups ...[]int) {
+	sim.partition = make(map[int]int)
+	for i := range sim.nodes {
+		sim.partition[i] = len(groups) + i
+	}
+	for group, indexes := range groups {
+		for _, index := range indexes {
+			sim.partition[index] = group
+		}
+	}
+}
+
+func (sim *Simulator) Heal() {
+	sim.partition = nil
+}
+
+func (sim *Simulator) reachable(from, to int) bool {
+	if sim.nodes[to].crashed {
+		return false
+	}
+	return from == to || sim.partition == nil || sim.partition[from] == sim.partition[to]
+}
+
+// 在网络延迟之后把消息交给节点to处理,分区或者丢包时消息被丢弃
+func (sim *Simulator) send(from, to int, fn func()) {
+	if !sim.reachable(from, to) {
+		return
+	}
+	if from != to && sim.rand.Float64() < sim.config.Loss {
+		return
+	}
+	delay := sim.config.Latency
+	if sim.config.Jitter > 0 {
+		delay += time.Duration(sim.rand.Int63n(int64(sim.config.Jitter)))
+	}
+	sim.schedule(delay, to, fn)
+}
+
+func (sim *Simulator) Height(index int) int64 {
+	return sim.nodes[index].Dbft.Blockchain.GetLastHeight()
+}
+
+func (sim *Simulator) FinalizedHeight(index int) int64 {
+	var height int64
+	sim.within(index, func() { height = sim.nodes[index].Dbft.FinalizedHeight() })
+	return height
+}
+
+func (sim *Simulator) HeaderHash(index int, height int64) []byte {
+	var hash []byte
+	sim.within(index, func() {
+		if header := encapdb.GetHeaderByHeight(CHAIN_ID, height); header != nil {
+			hash = header.CaculateHash()
+		}
+	})
+	return hash
+}
+
+// 校验所有节点不可逆的区块都相同,返回第一个冲突的高度
+func (sim *Simulator) CheckSafety() error {
+	finalized := make(map[int64][]byte)
+	for _, node := range sim.nodes {
+		for height := int64(0); height <= sim.FinalizedHeight(node.Index); height++ {
+			hash := sim.HeaderHash(node.Index, height)
+			if hash == nil {
+				return fmt.Errorf("node %d has no block at finalized height %d", node.Index, height)
+			}
+			if first, exist := finalized[height]; exist && !bytes.Equal(first, hash) {
+				return fmt.Errorf("conflicting finalized blocks at height %d: %s, %s", height, hex.EncodeToString(first), hex.EncodeToString(hash))
+			}
+			finalized[height] = hash
+		}
+	}
+	return nil
+}
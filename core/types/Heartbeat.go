@@ -2,30 +2,47 @@ package types
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"github.com/OpenOCC/OCC/crypto"
 	"strings"
 )
 
+// 心跳消息绑定发送节点、本地最新的高度和区块hash以及发送时间,接收方根据Timestamp拒绝过期和重放的心跳
 type Heartbeat struct {
-	Msg       HexBytes `json:"msg"`
 	Node      Peer     `json:"node"`
+	Height    int64    `json:"height"`
+	BlockHash HexBytes `json:"blockHash"`
+	Timestamp int64    `json:"timestamp"` // 毫秒时间戳
 	Signature HexBytes `json:"signature"`
 }
 
-func NewHeartbeat(node Peer) *Heartbeat {
+func NewHeartbeat(node Peer, height int64, blockHash []byte, timestamp int64) *Heartbeat {
 	return &Heartbeat{
-		Msg:  crypto.Sha3_256([]byte("123")),
-		Node: node,
+		Node:      node,
+		Height:    height,
+		BlockHash: blockHash,
+		Timestamp: timestamp,
 	}
 }
 
-func (beat Heartbeat) Sign(priv []byte) {
-	sign, _ := crypto.Crypto(beat.Msg, priv)
+// 签名的内容,不包括Signature
+func (beat Heartbeat) Msg() []byte {
+	beat.Signature = nil
+	data, _ := json.Marshal(beat)
+	return crypto.Sha3_256(data)
+}
+
+func (beat *Heartbeat) Sign(priv []byte) error {
+	sign, err := crypto.Crypto(beat.Msg(), priv)
+	if err != nil {
+		return err
+	}
 	beat.Signature = sign
+	return nil
 }
 
 func (beat Heartbeat) Validate() bool {
-	pubKey, err := crypto.RecoverPubKey(beat.Msg, beat.Signature)
+	pubKey, err := crypto.RecoverPubKey(beat.Msg(), beat.Signature)
 	if err != nil || !strings.EqualFold(hex.EncodeToString(FromPubKeyToAddress(pubKey)), beat.Node.Account) {
 		return false
 	}
@@ -12,6 +12,7 @@ type Account struct {
 	Nonce     int64                      `json:"nonce"`
 	Contracts map[string]ContractAccount `json:"contracts"`
 	Balances  map[string]int64           `json:"balances"`
+	Votes     map[string]int64           `json:"votes,omitempty"` // 投票给每个候选人锁定的数量,key是候选人地址
 }
 
 func CreateAccount(address []byte, Amount int64) Account {
@@ -57,6 +58,32 @@ func (account *Account) ReduceAmount(amount int64, gas int64) {
 	account.Nonce++
 }
 
+// 锁定amount投票给candidate
+func (account *Account) Vote(candidate string, amount int64) bool {
+	if amount <= 0 || account.Amount < amount {
+		return false
+	}
+	if account.Votes == nil {
+		account.Votes = make(map[string]int64)
+	}
+	account.Amount -= amount
+	account.Votes[candidate] += amount
+	return true
+}
+
+// 撤回对candidate的投票,解锁amount
+func (account *Account) Unvote(candidate string, amount int64) bool {
+	if amount <= 0 || account.Votes[candidate] < amount {
+		return false
+	}
+	account.Votes[candidate] -= amount
+	if account.Votes[candidate] == 0 {
+		delete(account.Votes, candidate)
+	}
+	account.Amount += amount
+	return true
+}
+
 func (account *Account) BurnGas(gas int64) {
 	account.Gas = account.Gas - gas
 }
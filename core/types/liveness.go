@@ -0,0 +1,65 @@
+package types
+
+import "sync"
+
+// 最近一次收到某个委托人的合法心跳
+type Liveness struct {
+	Peer       Peer     `json:"peer"`
+	Height     int64    `json:"height"`
+	BlockHash  HexBytes `json:"blockHash"`
+	Timestamp  int64    `json:"timestamp"`  // 心跳中的发送时间
+	ReceivedAt int64    `json:"receivedAt"` // 本地收到心跳的时间
+	Alive      bool     `json:"alive"`
+}
+
+// 按照委托人账户记录最近一次的心跳,心跳的Timestamp必须递增,重放的心跳不会被记录
+type LivenessTable struct {
+	records *sync.Map
+	locker  *sync.Mutex
+}
+
+func NewLivenessTable() LivenessTable {
+	return LivenessTable{
+		records: &sync.Map{},
+		locker:  &sync.Mutex{},
+	}
+}
+
+func (table LivenessTable) Get(account string) (Liveness, bool) {
+	obj, exist := table.records.Load(account)
+	if !exist {
+		return Liveness{}, false
+	}
+	return obj.(Liveness), true
+}
+
+// 记录now时收到的心跳,Timestamp不大于上一次记录的心跳时返回false
+func (table LivenessTable) Update(beat Heartbeat, now int64) bool {
+	table.locker.Lock()
+	defer table.locker.Unlock()
+	if last, exist := table.Get(beat.Node.Account); exist && beat.Timestamp <= last.Timestamp {
+		return false
+	}
+	table.records.Store(beat.Node.Account, Liveness{
+		Peer:       beat.Node,
+		Height:     beat.Height,
+		BlockHash:  beat.BlockHash,
+		Timestamp:  beat.Timestamp,
+		ReceivedAt: now,
+	})
+	return true
+}
+
+// 返回peers中每个委托人的状态,超过timeout毫秒没有收到心跳的委托人不存活
+func (table LivenessTable) Records(peers Peers, now, timeout int64) []Liveness {
+	records := make([]Liveness, 0, len(peers))
+	for _, peer := range peers {
+		record, exist := table.Get(peer.Account)
+		if !exist {
+			record = Liveness{Peer: peer}
+		}
+		record.Alive = exist && now-record.ReceivedAt <= timeout
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+func TestHeartbeatSign(t *testing.T) {
+	pub, priv := crypto.GenerateKeyPair()
+	peer := Peer{Account: hex.EncodeToString(FromPubKeyToAddress(pub))}
+	beat := NewHeartbeat(peer, 10, crypto.Sha3_256([]byte("block")), 1000)
+	if err := beat.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	if !beat.Validate() {
+		t.Fatal("signed heartbeat should be valid")
+	}
+
+	forged := *beat
+	forged.Timestamp = 2000
+	if forged.Validate() {
+		t.Fatal("heartbeat with a changed timestamp should be invalid")
+	}
+	forged = *beat
+	forged.Height = 11
+	if forged.Validate() {
+		t.Fatal("heartbeat with a changed height should be invalid")
+	}
+}
+
+func TestLivenessTable(t *testing.T) {
+	peers := Peers{{Account: "a"}, {Account: "b"}}
+	table := NewLivenessTable()
+	if !table.Update(Heartbeat{Node: peers[0], Timestamp: 1000}, 1000) {
+		t.Fatal("first heartbeat should be recorded")
+	}
+	if table.Update(Heartbeat{Node: peers[0], Timestamp: 1000}, 1500) {
+		t.Fatal("replayed heartbeat should be rejected")
+	}
+	if table.Update(Heartbeat{Node: peers[0], Timestamp: 900}, 1500) {
+		t.Fatal("older heartbeat should be rejected")
+	}
+
+	records := table.Records(peers, 2000, 3000)
+	if len(records) != 2 || !records[0].Alive || records[1].Alive {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if records := table.Records(peers, 5000, 3000); records[0].Alive {
+		t.Fatal("delegate without heartbeat for timeout should not be alive")
+	}
+}
@@ -4,6 +4,7 @@ import "sync"
 
 type Round struct {
 	CurrentIndex int          `json:"currentIndex"` // default -1
+	View         int          `json:"view"`         // 当前高度上已经被委托人投票跳过的出块节点数量
 	Peers        []Peer       `json:"peers"`
 	time         int64        `json:"-"`
 	Locker       sync.RWMutex `json:"-"`
@@ -28,8 +29,38 @@ func (round Round) GetTime() int64 {
 	return round.time
 }
 
+// 委托人集合变化之后重新设置Peers,CurrentIndex需要由之后的UpdateIndex重新计算
+func (round *Round) SetPeers(peers Peers) {
+	round.Locker.Lock()
+	round.Peers = peers
+	round.Locker.Unlock()
+}
+
+// 区块写入之后更新CurrentIndex,View重新从0开始
 func (round *Round) UpdateIndex(miner string) {
-	round.CurrentIndex = round.IndexOf(miner)
+	index := round.IndexOf(miner)
+	round.Locker.Lock()
+	round.CurrentIndex = index
+	round.View = 0
+	round.Locker.Unlock()
+}
+
+// 超过2/3的委托人投票跳过第view个出块节点之后CurrentIndex前进一位,由下一个节点出块
+// 只有view和当前的View一致时才会前进,重复的跳过结果返回false
+func (round *Round) Skip(view int) bool {
+	round.Locker.Lock()
+	defer round.Locker.Unlock()
+	if view != round.View {
+		return false
+	}
+	round.CurrentIndex = (round.CurrentIndex + 1) % round.Len()
+	round.View++
+	return true
+}
+
+// 当前应该出块的节点,只由CurrentIndex决定,不依赖本地时间
+func (round *Round) Packer() Peer {
+	return round.Peers[(round.CurrentIndex+1)%round.Len()]
 }
 
 func (round Round) IndexOf(miner string) int {
@@ -50,6 +81,7 @@ func (round Round) Clone() Round {
 	defer round.Locker.RUnlock()
 	return Round{
 		CurrentIndex: round.CurrentIndex,
+		View:         round.View,
 		Peers:        round.Peers,
 		time:         round.time,
 	}
@@ -0,0 +1,26 @@
+package types
+
+import "testing"
+
+func TestRoundSkip(t *testing.T) {
+	peers := Peers{{Account: "a"}, {Account: "b"}, {Account: "c"}}
+	round := NewRound(peers, -1, 0)
+	if round.Packer().Account != "a" {
+		t.Fatal("first packer should be a")
+	}
+	if !round.Skip(0) || round.Packer().Account != "b" {
+		t.Fatal("skip view 0 should move to b")
+	}
+	if round.Skip(0) {
+		t.Fatal("duplicate skip should be ignored")
+	}
+	round.Skip(1)
+	round.Skip(2)
+	if round.Packer().Account != "a" || round.View != 3 {
+		t.Fatal("skip should wrap around")
+	}
+	round.UpdateIndex("b")
+	if round.Packer().Account != "c" || round.View != 0 {
+		t.Fatal("update index should reset view")
+	}
+}
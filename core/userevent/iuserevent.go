@@ -6,8 +6,16 @@ import (
 	"github.com/OpenOCC/OCC/crypto"
 )
 
-func ValidateTransaction(transaction Transaction) bool {
-	pubKey, err := crypto.RecoverPubKey(transaction.Msg(), transaction.GetSign())
+// 校验交易在chainId的链上height高度的签名,height不小于legacySignHeight时只接受二进制签名
+func ValidateTransaction(transaction Transaction, chainId, height int64) bool {
+	if transaction.SignVersion == TX_SIGN_VERSION_LEGACY && !LegacySignAllowed(height) {
+		return false
+	}
+	msg := transaction.SignMsg(chainId)
+	if msg == nil {
+		return false
+	}
+	pubKey, err := crypto.RecoverPubKey(msg, transaction.GetSign())
 	if err != nil {
 		return false
 	}
@@ -15,8 +23,10 @@ func ValidateTransaction(transaction Transaction) bool {
 	return result
 }
 
-func SignTransaction(transaction *Transaction, privKey []byte) error {
-	sign, err := crypto.Crypto(transaction.Msg(), privKey)
+// 使用TX_SIGN_VERSION_BINARY对交易签名
+func SignTransaction(transaction *Transaction, chainId int64, privKey []byte) error {
+	transaction.SignVersion = TX_SIGN_VERSION_BINARY
+	sign, err := crypto.Crypto(transaction.SignMsg(chainId), privKey)
 	if err != nil {
 		return err
 	}
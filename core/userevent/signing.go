@@ -0,0 +1,73 @@
+package userevent
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+// 交易签名内容的版本,TX_SIGN_VERSION_LEGACY的交易序列化时省略SignVersion,保证原有交易的TxId不变
+const (
+	TX_SIGN_VERSION_LEGACY = 0 // 签名fmt.Sprintf拼接的JSON字符串的hash,Data和TokenAddress没有转义
+	TX_SIGN_VERSION_BINARY = 1 // 签名规范二进制编码的hash,包括chainId
+)
+
+// 高度不小于legacySignHeight时不再接受TX_SIGN_VERSION_LEGACY签名的交易,默认一直接受
+var legacySignHeight int64 = math.MaxInt64
+
+// 设置停止接受旧格式签名的高度,height不大于0时一直接受
+func SetLegacySignHeight(height int64) {
+	if height <= 0 {
+		height = math.MaxInt64
+	}
+	legacySignHeight = height
+}
+
+func LegacySignAllowed(height int64) bool {
+	return height < legacySignHeight
+}
+
+/*
+*TX_SIGN_VERSION_BINARY的签名内容,整数都是8字节大端编码,字节串和字符串前面是4字节大端编码的长度
+*依次为:SignVersion(1字节)、chainId、Type、From、To、TimeStamp、Amount、Fee、Nonce、Data、TokenAddress
+*chainId不在交易中,由签名方和校验方各自提供,其他链上的签名不能在本链重放
+ */
+func (tx Transaction) SigningPayload(chainId int64) []byte {
+	payload := make([]byte, 0, 1+6*8+5*4+len(tx.From)+len(tx.To)+len(tx.Data)+len(tx.TokenAddress))
+	payload = append(payload, byte(TX_SIGN_VERSION_BINARY))
+	for _, n := range []int64{chainId, int64(tx.Type)} {
+		payload = appendInt64(payload, n)
+	}
+	payload = appendBytes(payload, tx.From)
+	payload = appendBytes(payload, tx.To)
+	for _, n := range []int64{tx.TimeStamp, tx.Amount, tx.Fee, tx.Nonce} {
+		payload = appendInt64(payload, n)
+	}
+	payload = appendBytes(payload, []byte(tx.Data))
+	payload = appendBytes(payload, []byte(tx.TokenAddress))
+	return payload
+}
+
+// 根据SignVersion返回需要签名的hash,不支持的版本返回nil
+func (tx Transaction) SignMsg(chainId int64) []byte {
+	switch tx.SignVersion {
+	case TX_SIGN_VERSION_LEGACY:
+		return tx.Msg()
+	case TX_SIGN_VERSION_BINARY:
+		return crypto.Sha3_256(tx.SigningPayload(chainId))
+	}
+	return nil
+}
+
+func appendInt64(payload []byte, n int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	return append(payload, buf[:]...)
+}
+
+func appendBytes(payload, data []byte) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(len(data)))
+	return append(append(payload, buf[:]...), data...)
+}
@@ -0,0 +1,95 @@
+package userevent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/crypto"
+)
+
+// testdata/sign_vectors.json可以直接提供给外部钱包,签名是确定性的,同样的私钥和交易得到同样的签名
+type signVector struct {
+	Name        string      `json:"name"`
+	ChainId     int64       `json:"chainId"`
+	PrivateKey  string      `json:"privateKey"`
+	Transaction Transaction `json:"transaction"`
+	Payload     string      `json:"payload"`
+	Msg         string      `json:"msg"`
+	TxId        string      `json:"txId"`
+}
+
+func TestSignVectors(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sign_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []signVector
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		tx := v.Transaction
+		if tx.SignVersion == TX_SIGN_VERSION_BINARY && hex.EncodeToString(tx.SigningPayload(v.ChainId)) != v.Payload {
+			t.Errorf("%s: unexpected payload %x", v.Name, tx.SigningPayload(v.ChainId))
+		}
+		if hex.EncodeToString(tx.SignMsg(v.ChainId)) != v.Msg {
+			t.Errorf("%s: unexpected msg %x", v.Name, tx.SignMsg(v.ChainId))
+		}
+		if tx.TransactionId() != v.TxId {
+			t.Errorf("%s: unexpected txId %s", v.Name, tx.TransactionId())
+		}
+		priv, _ := hex.DecodeString(v.PrivateKey)
+		sign, err := crypto.Crypto(tx.SignMsg(v.ChainId), priv)
+		if err != nil || !bytes.Equal(sign, tx.Sign) {
+			t.Errorf("%s: unexpected signature %x", v.Name, sign)
+		}
+		if !ValidateTransaction(tx, v.ChainId, 1) {
+			t.Errorf("%s: signature should be valid", v.Name)
+		}
+		if tx.SignVersion == TX_SIGN_VERSION_BINARY && ValidateTransaction(tx, v.ChainId+1, 1) {
+			t.Errorf("%s: signature should not be valid on another chain", v.Name)
+		}
+	}
+}
+
+func TestLegacySignHeight(t *testing.T) {
+	defer SetLegacySignHeight(0)
+	pub, priv := crypto.GenerateKeyPair()
+	tx := NewTransaction(types.FromPubKeyToAddress(pub), crypto.Sha3_256([]byte("to")), 0, 1, 1, 1, "", "")
+	legacy := *tx
+	sign, _ := crypto.Crypto(legacy.Msg(), priv)
+	legacy.Sign = sign
+
+	SetLegacySignHeight(100)
+	if !ValidateTransaction(legacy, 1, 99) || ValidateTransaction(legacy, 1, 100) {
+		t.Fatal("legacy signature should only be accepted before the legacy sign height")
+	}
+	binary := *tx
+	if err := SignTransaction(&binary, 1, priv); err != nil {
+		t.Fatal(err)
+	}
+	if !ValidateTransaction(binary, 1, 100) {
+		t.Fatal("binary signature should be accepted after the legacy sign height")
+	}
+	binary.SignVersion = TX_SIGN_VERSION_BINARY + 1
+	if ValidateTransaction(binary, 1, 1) {
+		t.Fatal("unknown sign version should be rejected")
+	}
+}
+
+// 旧格式没有转义Data和TokenAddress,不同的交易可以得到相同的签名内容,二进制编码不会
+func TestSigningPayloadUnambiguous(t *testing.T) {
+	to := crypto.Sha3_256([]byte("to"))
+	tx1 := NewTransaction(nil, to, 0, 1, 1, 1, `a", "tokenAddress": "b`, "")
+	tx2 := NewTransaction(nil, to, 0, 1, 1, 1, "a", `b", "tokenAddress": "`)
+	if !bytes.Equal(tx1.Msg(), tx2.Msg()) {
+		t.Fatal("legacy messages are expected to collide")
+	}
+	if bytes.Equal(tx1.SigningPayload(1), tx2.SigningPayload(1)) {
+		t.Fatal("binary payloads should differ")
+	}
+}
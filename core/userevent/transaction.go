@@ -18,6 +18,16 @@ const (
 	FailType_NO_ENOUGH_AMOUNT
 
 	FailType_CONTRACT_ERROR
+	FailType_INVALID_DELEGATE
+)
+
+// 交易类型,普通转账交易的Type为0,序列化时省略,保证原有交易的TxId不变
+const (
+	TX_TYPE_TRANSFER            = 0
+	TX_TYPE_REGISTER_DELEGATE   = 1 // 注册成为委托人候选,Data为节点地址信息
+	TX_TYPE_UNREGISTER_DELEGATE = 2 // 取消委托人候选
+	TX_TYPE_VOTE                = 3 // 锁定Amount投票给To对应的候选人
+	TX_TYPE_UNVOTE              = 4 // 撤回对To的投票,解锁Amount
 )
 
 type Transactions []Transaction
@@ -33,6 +43,8 @@ type Transaction struct {
 	Data         string         `json:"data"`
 	TokenAddress string         `json:"tokenAddress"`
 	Sign         types.HexBytes `json:"sign"`
+	Type         int            `json:"type,omitempty"`
+	SignVersion  int            `json:"signVersion,omitempty"` // Sign签名内容的版本,见TX_SIGN_VERSION_LEGACY
 }
 
 type SubTransaction struct {
@@ -162,10 +174,19 @@ func (tx *Transaction) TxId() []byte {
 }
 
 func (tx *Transaction) String() string {
+	if tx.Type != TX_TYPE_TRANSFER {
+		return fmt.Sprintf(`{"from": "%s", "to": "%s", "time": %d, "amount": %d, "fee": %d, "nonce": %d, "data": "%s", "tokenAddress": "%s", "type": %d}`,
+			hex.EncodeToString(tx.From), hex.EncodeToString(tx.To), tx.TimeStamp, tx.Amount, tx.Fee, tx.Nonce, tx.Data, tx.TokenAddress, tx.Type)
+	}
 	return fmt.Sprintf(`{"from": "%s", "to": "%s", "time": %d, "amount": %d, "fee": %d, "nonce": %d, "data": "%s", "tokenAddress": "%s"}`,
 		hex.EncodeToString(tx.From), hex.EncodeToString(tx.To), tx.TimeStamp, tx.Amount, tx.Fee, tx.Nonce, tx.Data, tx.TokenAddress)
 }
 
+func (receipt TransactionReceipt) Bytes() []byte {
+	data, _ := json.Marshal(receipt)
+	return data
+}
+
 func (tx Transaction) Bytes() []byte {
 	data, _ := json.Marshal(tx)
 	return data
@@ -1,35 +1,44 @@
 package db
 
+// ComposedKVDatabase在levelDB前面加了一层有容量限制的LRU缓存
 type ComposedKVDatabase struct {
-	mem     *MemKVDatabase
+	cache   *LRUCache
 	levelDB *LevelDB
 }
 
-func NewComposedKVDatabase(filePath string) *ComposedKVDatabase {
+func NewComposedKVDatabase(filePath string, cacheSize int64) *ComposedKVDatabase {
 	return &ComposedKVDatabase{
-		mem:     NewMemKVDatabase(),
+		cache:   NewLRUCache(cacheSize),
 		levelDB: NewLevelDB(filePath),
 	}
 }
 
 func (db *ComposedKVDatabase) Set(key, value []byte) error {
-	db.mem.Set(key, value)
-	go db.levelDB.Set(key, value)
-	return nil
+	db.cache.Set(key, value, int64(len(key)+len(value)))
+	return db.levelDB.Set(key, value)
 }
 
 func (db *ComposedKVDatabase) Get(key []byte) (value []byte, err error) {
-	value, err = db.mem.Get(key)
-	if err != nil {
-		value, err = db.levelDB.Get(key)
-		if err == nil {
-			db.mem.Set(key, value)
-		}
+	if v, exist := db.cache.Get(key); exist {
+		return v.([]byte), nil
+	}
+	value, err = db.levelDB.Get(key)
+	if err == nil {
+		db.cache.Set(key, value, int64(len(key)+len(value)))
 	}
 	return
 }
 
 func (db *ComposedKVDatabase) Delete(key []byte) error {
-	db.mem.Delete(key)
+	db.cache.Delete(key)
 	return db.levelDB.Delete(key)
 }
+
+// 缓存是写穿透的,遍历直接读取levelDB
+func (db *ComposedKVDatabase) ReverseIterate(prefix, start []byte, fn func(key, value []byte) bool) error {
+	return db.levelDB.ReverseIterate(prefix, start, fn)
+}
+
+func (db *ComposedKVDatabase) CacheStats() CacheStats {
+	return db.cache.Stats()
+}
@@ -4,4 +4,7 @@ type IKVDatabase interface {
 	Set(key, value []byte) error
 	Get(key []byte) ([]byte, error)
 	Delete(key []byte) error
+	// 按照key从大到小遍历前缀为prefix并且不大于start的记录,start为nil时从最大的key开始,fn返回false时停止
+	// fn中的key和value在返回之后可能被复用,需要保留时由调用方复制
+	ReverseIterate(prefix, start []byte, fn func(key, value []byte) bool) error
 }
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"bytes"
 	"encoding/hex"
+	"sort"
 	"sync"
 )
 
@@ -36,3 +38,27 @@ func (db *MemKVDatabase) Delete(key []byte) error {
 	db.Map.Delete(hex.EncodeToString(key))
 	return nil
 }
+
+func (db *MemKVDatabase) ReverseIterate(prefix, start []byte, fn func(key, value []byte) bool) error {
+	keys := make([][]byte, 0)
+	db.Map.Range(func(k, v interface{}) bool {
+		key, _ := hex.DecodeString(k.(string))
+		if bytes.HasPrefix(key, prefix) && (start == nil || bytes.Compare(key, start) <= 0) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) > 0
+	})
+	for _, key := range keys {
+		value, err := db.Get(key)
+		if err != nil {
+			continue
+		}
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
@@ -1,8 +1,17 @@
 package db
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
 
 var (
 	NoSuchKeyError   = errors.New("no such key in database")
 	InvalidTypeError = errors.New("invalid result type")
 )
+
+// key不存在时各个DB返回的错误,用于区分不存在和读取失败
+func IsNotFound(err error) bool {
+	return err == NoSuchKeyError || err == leveldb.ErrNotFound
+}
@@ -1,9 +1,15 @@
 package db
 
+// 默认的缓存大小,单位byte
+const DefaultCacheSize = 64 * 1024 * 1024
+
 var EktDB IKVDatabase
 
-func InitEKTDB(filePath string) {
-	EktDB = NewComposedKVDatabase(filePath)
+func InitEKTDB(filePath string, cacheSize int64) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+	EktDB = NewComposedKVDatabase(filePath, cacheSize)
 }
 
 func GetDBInst() IKVDatabase {
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"bytes"
+
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type LevelDB struct {
@@ -27,3 +30,24 @@ func (levelDB LevelDB) Get(key []byte) ([]byte, error) {
 func (levelDB LevelDB) Delete(key []byte) error {
 	return levelDB.DB.Delete(key, nil)
 }
+
+func (levelDB LevelDB) ReverseIterate(prefix, start []byte, fn func(key, value []byte) bool) error {
+	iter := levelDB.DB.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	var ok bool
+	switch {
+	case start == nil:
+		ok = iter.Last()
+	case iter.Seek(start):
+		// Seek定位到第一个不小于start的key
+		ok = bytes.Equal(iter.Key(), start) || iter.Prev()
+	default:
+		ok = iter.Last()
+	}
+	for ; ok; ok = iter.Prev() {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
@@ -1,8 +1,45 @@
 package db
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
 func TestLevelDB(t *testing.T) {
 }
+
+func TestReverseIterate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, database := range []IKVDatabase{NewMemKVDatabase(), NewLevelDB(dir)} {
+		for i := 1; i <= 5; i++ {
+			database.Set([]byte(fmt.Sprintf("a_%d", i)), []byte{byte(i)})
+		}
+		database.Set([]byte("b_1"), []byte{0})
+		iterate := func(start []byte, limit int) []byte {
+			values := make([]byte, 0)
+			database.ReverseIterate([]byte("a_"), start, func(key, value []byte) bool {
+				values = append(values, value[0])
+				return len(values) < limit
+			})
+			return values
+		}
+		if values := iterate(nil, 10); string(values) != string([]byte{5, 4, 3, 2, 1}) {
+			t.Fatalf("%T: unexpected values %v", database, values)
+		}
+		if values := iterate([]byte("a_3"), 2); string(values) != string([]byte{3, 2}) {
+			t.Fatalf("%T: iteration should start at the start key, got %v", database, values)
+		}
+		if values := iterate([]byte("a_35"), 10); string(values) != string([]byte{3, 2, 1}) {
+			t.Fatalf("%T: iteration should start before a missing start key, got %v", database, values)
+		}
+		if values := iterate([]byte("a_9"), 1); string(values) != string([]byte{5}) {
+			t.Fatalf("%T: iteration should start at the last key, got %v", database, values)
+		}
+	}
+}
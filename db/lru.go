@@ -0,0 +1,104 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+type CacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Size     int64 `json:"size"`
+	Capacity int64 `json:"capacity"`
+	Count    int   `json:"count"`
+}
+
+/*
+*LRUCache是按照占用内存大小淘汰的LRU缓存,size由调用方估算
+*capacity小于等于0时不缓存任何数据
+ */
+type LRUCache struct {
+	capacity int64
+	size     int64
+	hits     int64
+	misses   int64
+	list     *list.List
+	items    map[string]*list.Element
+	locker   sync.Mutex
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+func NewLRUCache(capacity int64) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		list:     list.New(),
+		items:    make(map[string]*list.Element),
+		locker:   sync.Mutex{},
+	}
+}
+
+func (cache *LRUCache) Get(key []byte) (interface{}, bool) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	element, exist := cache.items[string(key)]
+	if !exist {
+		atomic.AddInt64(&cache.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&cache.hits, 1)
+	cache.list.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (cache *LRUCache) Set(key []byte, value interface{}, size int64) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	if size > cache.capacity {
+		cache.remove(string(key))
+		return
+	}
+	if element, exist := cache.items[string(key)]; exist {
+		entry := element.Value.(*lruEntry)
+		cache.size += size - entry.size
+		entry.value, entry.size = value, size
+		cache.list.MoveToFront(element)
+	} else {
+		cache.items[string(key)] = cache.list.PushFront(&lruEntry{key: string(key), value: value, size: size})
+		cache.size += size
+	}
+	for cache.size > cache.capacity {
+		cache.remove(cache.list.Back().Value.(*lruEntry).key)
+	}
+}
+
+func (cache *LRUCache) Delete(key []byte) {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	cache.remove(string(key))
+}
+
+func (cache *LRUCache) remove(key string) {
+	if element, exist := cache.items[key]; exist {
+		cache.list.Remove(element)
+		delete(cache.items, key)
+		cache.size -= element.Value.(*lruEntry).size
+	}
+}
+
+func (cache *LRUCache) Stats() CacheStats {
+	cache.locker.Lock()
+	defer cache.locker.Unlock()
+	return CacheStats{
+		Hits:     atomic.LoadInt64(&cache.hits),
+		Misses:   atomic.LoadInt64(&cache.misses),
+		Size:     cache.size,
+		Capacity: cache.capacity,
+		Count:    len(cache.items),
+	}
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(10)
+	cache.Set([]byte("a"), 1, 4)
+	cache.Set([]byte("b"), 2, 4)
+	if _, exist := cache.Get([]byte("a")); !exist {
+		t.Fatal("a should be cached")
+	}
+	// b是最久没有访问的,超过容量时被淘汰
+	cache.Set([]byte("c"), 3, 4)
+	if _, exist := cache.Get([]byte("b")); exist {
+		t.Fatal("b should be evicted")
+	}
+	if v, exist := cache.Get([]byte("c")); !exist || v.(int) != 3 {
+		t.Fatal("c should be cached")
+	}
+	cache.Set([]byte("d"), 4, 11)
+	if _, exist := cache.Get([]byte("d")); exist {
+		t.Fatal("value larger than capacity should not be cached")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Size != 8 || stats.Count != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
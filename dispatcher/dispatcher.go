@@ -24,7 +24,8 @@ func NewTransaction(transaction *userevent.Transaction) error {
 			return err
 		}
 	}
-	if !userevent.ValidateTransaction(*transaction) {
+	chain := node.GetMainChain()
+	if !userevent.ValidateTransaction(*transaction, chain.ChainId, chain.GetLastHeight()+1) {
 		return errors.New("error signature")
 	}
 	if bytes.EqualFold(transaction.GetFrom(), transaction.GetTo()) {
@@ -3,6 +3,8 @@ package encapdb
 import (
 	"github.com/OpenOCC/OCC/blockchain"
 	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
 	"github.com/OpenOCC/OCC/db"
 	"github.com/OpenOCC/OCC/encapdb/schema"
 )
@@ -16,10 +18,35 @@ func GetBlockByHeight(chainId, height int64) *blockchain.Block {
 	return blockchain.GetBlockFromBytes(data)
 }
 func SetBlockByHeight(chainId, height int64, block blockchain.Block) {
+	SetBlockBody(&block)
 	key := schema.GetBlockByHeightKey(chainId, height)
 	db.GetDBInst().Set(key, block.Bytes())
 }
 
+// 把区块头、交易和回执分别按照区块hash、交易列表的Sha3和ReceiptHash写入本地数据库
+// TxHash从HEADER_VERSION_TX_MERKLE开始是Merkle根,所以交易列表的key记录在区块的BodyHash中
+func SetBlockBody(block *blockchain.Block) {
+	header := block.GetHeader()
+	if header == nil {
+		return
+	}
+	db.GetDBInst().Set(header.CaculateHash(), header.Bytes())
+	if txs := block.GetTransactions(); txs != nil && len(header.TxHash) != 0 {
+		body := userevent.Transactions(txs).Bytes()
+		block.BodyHash = crypto.Sha3_256(body)
+		db.GetDBInst().Set(block.BodyHash, body)
+	}
+	if receipts := block.GetTxReceipts(); receipts != nil && len(header.ReceiptHash) != 0 {
+		db.GetDBInst().Set(header.ReceiptHash, userevent.Receipts(receipts).Bytes())
+	}
+}
+
+// 回滚时删除指定高度的区块和区块头索引
+func DeleteHeight(chainId, height int64) {
+	db.GetDBInst().Delete(schema.GetBlockByHeightKey(chainId, height))
+	db.GetDBInst().Delete(schema.GetHeaderByHeightKey(chainId, height))
+}
+
 func GetHeaderByHeight(chainId, height int64) *blockchain.Header {
 	key := schema.GetHeaderByHeightKey(chainId, height)
 	hash, err := db.GetDBInst().Get(key)
@@ -0,0 +1,48 @@
+package encapdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/core/types"
+	"github.com/OpenOCC/OCC/core/userevent"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func TestSetBlockBody(t *testing.T) {
+	log.InitLog("/tmp/encapdb_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	blockchain.SetVersionHeights(make([]int64, blockchain.HEADER_VERSION_LATEST+1))
+	defer blockchain.SetVersionHeights(nil)
+
+	accounts := make([]types.Account, 0)
+	for i := 0; i < 2; i++ {
+		account := types.CreateAccount(crypto.Sha3_256([]byte{byte(i)}), 100)
+		account.Gas = 100
+		accounts = append(accounts, account)
+	}
+	genesis := blockchain.CreateGenesisBlock(accounts)
+	block := blockchain.CreateBlock(*genesis.GetHeader(), types.Peer{Account: hex.EncodeToString(accounts[0].Address)})
+	tx := userevent.NewTransaction(accounts[0].Address, accounts[1].Address, 0, 10, 1, 1, "", "")
+	block.NewTransaction(*tx)
+	block.Finish()
+	received := blockchain.GetBlockMessageFromBytes(blockchain.NewBlockMessage(*block).Bytes()).ToBlock()
+
+	// 接收方的数据库中只有按照区块体内容写入的数据
+	db.EktDB = db.NewMemKVDatabase()
+	SetBlockByHeight(1, 1, *received)
+	if _, err := db.GetDBInst().Get(received.GetHeader().TxHash); err == nil {
+		t.Fatal("block body should not be stored under the Merkle root")
+	}
+	stored := GetBlockByHeight(1, 1)
+	if stored == nil || !bytes.Equal(stored.BodyHash, crypto.Sha3_256(userevent.Transactions{*tx}.Bytes())) {
+		t.Fatal("stored block should record the content hash of its body")
+	}
+	if txs := stored.GetTransactions(); len(txs) != 1 || txs[0].TransactionId() != tx.TransactionId() {
+		t.Fatalf("transactions should be read by the body hash, got %v", txs)
+	}
+}
@@ -0,0 +1,97 @@
+package encapdb
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb/schema"
+)
+
+var evidenceLocker sync.Mutex
+
+// 保存作恶证据,同一个Key的证据只保存第一个,返回是否是新的证据
+func SetEvidence(chainId int64, evidence blockchain.Evidence) bool {
+	evidenceLocker.Lock()
+	defer evidenceLocker.Unlock()
+	key := evidence.Key()
+	if GetEvidence(chainId, key) != nil {
+		return false
+	}
+	db.GetDBInst().Set(schema.EvidenceKey(chainId, key), evidence.Bytes())
+	keys := append(getEvidenceKeys(chainId), key)
+	data, _ := json.Marshal(keys)
+	db.GetDBInst().Set(schema.EvidenceListKey(chainId), data)
+	return true
+}
+
+func GetEvidence(chainId int64, key string) *blockchain.Evidence {
+	data, err := db.GetDBInst().Get(schema.EvidenceKey(chainId, key))
+	if err != nil {
+		return nil
+	}
+	var evidence blockchain.Evidence
+	if err = json.Unmarshal(data, &evidence); err != nil {
+		return nil
+	}
+	return &evidence
+}
+
+// 按照发现的顺序返回所有的作恶证据
+func GetEvidences(chainId int64) blockchain.Evidences {
+	evidences := make(blockchain.Evidences, 0)
+	for _, key := range getEvidenceKeys(chainId) {
+		if evidence := GetEvidence(chainId, key); evidence != nil {
+			evidences = append(evidences, *evidence)
+		}
+	}
+	return evidences
+}
+
+// 返回还没有被打包到区块中的作恶证据,最多limit个
+func GetPendingEvidences(chainId int64, limit int) blockchain.Evidences {
+	evidences := make(blockchain.Evidences, 0)
+	for _, evidence := range GetEvidences(chainId) {
+		if len(evidences) >= limit {
+			break
+		}
+		if GetEvidenceIncludedHeight(chainId, evidence.Key()) < 0 {
+			evidences = append(evidences, evidence)
+		}
+	}
+	return evidences
+}
+
+// 返回证据被打包的区块高度,-1表示还没有被打包
+func GetEvidenceIncludedHeight(chainId int64, key string) int64 {
+	data, err := db.GetDBInst().Get(schema.EvidenceIncludedKey(chainId, key))
+	if err != nil {
+		return -1
+	}
+	height, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return height
+}
+
+func SetEvidenceIncluded(chainId int64, key string, height int64) error {
+	return db.GetDBInst().Set(schema.EvidenceIncludedKey(chainId, key), []byte(strconv.FormatInt(height, 10)))
+}
+
+// 回滚之后证据重新等待打包
+func DeleteEvidenceIncluded(chainId int64, key string) error {
+	return db.GetDBInst().Delete(schema.EvidenceIncludedKey(chainId, key))
+}
+
+func getEvidenceKeys(chainId int64) []string {
+	data, err := db.GetDBInst().Get(schema.EvidenceListKey(chainId))
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	json.Unmarshal(data, &keys)
+	return keys
+}
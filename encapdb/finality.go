@@ -0,0 +1,54 @@
+package encapdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb/schema"
+)
+
+// 返回已经不可逆的最高高度,-1表示还没有记录
+func GetFinalizedHeight(chainId int64) int64 {
+	data, err := db.GetDBInst().Get(schema.FinalizedHeightKey(chainId))
+	if err != nil {
+		return -1
+	}
+	height, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return height
+}
+
+// 不可逆高度只能增加
+func SetFinalizedHeight(chainId, height int64) error {
+	if height <= GetFinalizedHeight(chainId) {
+		return nil
+	}
+	return db.GetDBInst().Set(schema.FinalizedHeightKey(chainId), []byte(strconv.FormatInt(height, 10)))
+}
+
+// 从指定高度向前查找连续两个高度都保存了voteResult的区块,前一个区块不可逆,用于没有记录不可逆高度的旧数据
+// 创世块不需要投票,所以至少返回0,本地没有区块时返回-1
+func RecoverFinalizedHeight(chainId, height int64) int64 {
+	for ; height > 1; height-- {
+		header, parent := GetHeaderByHeight(chainId, height), GetHeaderByHeight(chainId, height-1)
+		if header == nil || parent == nil || !bytes.Equal(header.PreviousHash, parent.CaculateHash()) {
+			continue
+		}
+		if hasVoteResults(chainId, *header) && hasVoteResults(chainId, *parent) {
+			return height - 1
+		}
+	}
+	if GetHeaderByHeight(chainId, 0) == nil {
+		return -1
+	}
+	return 0
+}
+
+func hasVoteResults(chainId int64, header blockchain.Header) bool {
+	return len(GetVoteResults(chainId, hex.EncodeToString(header.CaculateHash()))) > 0
+}
@@ -0,0 +1,62 @@
+package encapdb
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func TestSetFinalizedHeight(t *testing.T) {
+	log.InitLog("/tmp/encapdb_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+
+	if height := GetFinalizedHeight(1); height != -1 {
+		t.Fatalf("finalized height should be -1 before any record, got %d", height)
+	}
+	SetFinalizedHeight(1, 5)
+	SetFinalizedHeight(1, 3)
+	if height := GetFinalizedHeight(1); height != 5 {
+		t.Fatalf("finalized height should not decrease, got %d", height)
+	}
+	if height := GetFinalizedHeight(2); height != -1 {
+		t.Fatalf("finalized height of another chain should not be set, got %d", height)
+	}
+}
+
+func TestRecoverFinalizedHeight(t *testing.T) {
+	log.InitLog("/tmp/encapdb_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+
+	if height := RecoverFinalizedHeight(1, 10); height != -1 {
+		t.Fatalf("empty chain should have no finalized height, got %d", height)
+	}
+	// 高度1到3有voteResult,高度4没有
+	var previous []byte
+	for height := int64(0); height <= 4; height++ {
+		header := blockchain.Header{Height: height, PreviousHash: previous, Timestamp: height}
+		SetHeaderByHeight(1, height, header)
+		previous = header.CaculateHash()
+		if height > 0 && height < 4 {
+			vote := blockchain.PeerBlockVote{Vote: blockchain.BlockVoteDetail{BlockHash: previous, BlockHeight: height}}
+			SetVoteResults(1, hex.EncodeToString(previous), blockchain.Votes{vote})
+		}
+	}
+	if height := RecoverFinalizedHeight(1, 4); height != 2 {
+		t.Fatalf("block 2 should be the last block with a voted child, got %d", height)
+	}
+	if height := RecoverFinalizedHeight(1, 1); height != 0 {
+		t.Fatalf("genesis block should always be finalized, got %d", height)
+	}
+
+	// 高度3的区块不是高度2的子区块时,高度2不可逆的依据不成立
+	fork := blockchain.Header{Height: 3, Timestamp: 3}
+	SetHeaderByHeight(1, 3, fork)
+	vote := blockchain.PeerBlockVote{Vote: blockchain.BlockVoteDetail{BlockHash: fork.CaculateHash(), BlockHeight: 3}}
+	SetVoteResults(1, hex.EncodeToString(fork.CaculateHash()), blockchain.Votes{vote})
+	if height := RecoverFinalizedHeight(1, 4); height != 1 {
+		t.Fatalf("child on another branch should not finalize its height, got %d", height)
+	}
+}
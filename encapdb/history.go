@@ -0,0 +1,79 @@
+package encapdb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb/schema"
+)
+
+var historyLocker sync.Mutex
+
+/*
+*每个地址在每个高度的交易记录保存为一个key,key中的高度补齐位数,按照key的前缀倒序遍历就是按照高度从高到低
+*交易的From和To都会记录,From和To相同时只记录一次
+ */
+func AddHistory(chainId int64, entries []blockchain.HistoryEntry) {
+	historyLocker.Lock()
+	defer historyLocker.Unlock()
+	for _, entry := range entries {
+		addresses := []string{hex.EncodeToString(entry.From)}
+		if to := hex.EncodeToString(entry.To); to != addresses[0] {
+			addresses = append(addresses, to)
+		}
+		for _, address := range addresses {
+			data, _ := json.Marshal(append(getHistoryAt(chainId, address, entry.Height), entry))
+			db.GetDBInst().Set(schema.HistoryKey(chainId, address, entry.Height), data)
+		}
+	}
+}
+
+// 回滚时删除entries中所有地址在这些高度上的记录
+func DeleteHistory(chainId int64, entries []blockchain.HistoryEntry) {
+	historyLocker.Lock()
+	defer historyLocker.Unlock()
+	for _, entry := range entries {
+		for _, address := range []string{hex.EncodeToString(entry.From), hex.EncodeToString(entry.To)} {
+			db.GetDBInst().Delete(schema.HistoryKey(chainId, address, entry.Height))
+		}
+	}
+}
+
+/*
+*从高到低返回地址在cursor及之前高度的交易记录,cursor小于0时从最新的记录开始
+*同一个高度的记录一起返回,所以数量可能超过limit,next是下一页的cursor,-1表示没有更多记录
+ */
+func GetHistory(chainId int64, address string, cursor int64, limit int) ([]blockchain.HistoryEntry, int64) {
+	entries := make([]blockchain.HistoryEntry, 0, limit)
+	next := int64(-1)
+	var start []byte
+	if cursor >= 0 {
+		start = schema.HistoryKey(chainId, address, cursor)
+	}
+	db.GetDBInst().ReverseIterate(schema.HistoryPrefix(chainId, address), start, func(key, value []byte) bool {
+		var _entries []blockchain.HistoryEntry
+		if json.Unmarshal(value, &_entries) != nil || len(_entries) == 0 {
+			return true
+		}
+		if len(entries) >= limit {
+			next = _entries[0].Height
+			return false
+		}
+		entries = append(entries, _entries...)
+		return true
+	})
+	return entries, next
+}
+
+func getHistoryAt(chainId int64, address string, height int64) []blockchain.HistoryEntry {
+	data, err := db.GetDBInst().Get(schema.HistoryKey(chainId, address, height))
+	if err != nil {
+		return nil
+	}
+	var entries []blockchain.HistoryEntry
+	json.Unmarshal(data, &entries)
+	return entries
+}
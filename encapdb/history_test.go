@@ -0,0 +1,55 @@
+package encapdb
+
+import (
+	"testing"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func TestHistory(t *testing.T) {
+	log.InitLog("/tmp/encapdb_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+
+	alice, bob, carol := []byte{1}, []byte{2}, []byte{3}
+	for height := int64(1); height <= 5; height++ {
+		AddHistory(1, []blockchain.HistoryEntry{
+			{TxId: "a", Height: height, Index: 0, From: alice, To: bob},
+			{TxId: "b", Height: height, Index: 1, From: bob, To: carol},
+		})
+	}
+
+	entries, next := GetHistory(1, "01", -1, 2)
+	if len(entries) != 2 || entries[0].Height != 5 || entries[1].Height != 4 || next != 3 {
+		t.Fatalf("unexpected first page %v, next %d", entries, next)
+	}
+	entries, next = GetHistory(1, "01", next, 10)
+	if len(entries) != 3 || entries[2].Height != 1 || next != -1 {
+		t.Fatalf("unexpected last page %v, next %d", entries, next)
+	}
+	if entries, _ := GetHistory(1, "02", -1, 1); len(entries) != 2 || entries[0].Index != 0 || entries[1].Index != 1 {
+		t.Fatalf("entries at the same height should be returned together, got %v", entries)
+	}
+
+	DeleteHistory(1, []blockchain.HistoryEntry{
+		{TxId: "a", Height: 5, From: alice, To: bob},
+		{TxId: "b", Height: 5, From: bob, To: carol},
+	})
+	if entries, _ := GetHistory(1, "03", -1, 10); len(entries) != 4 || entries[0].Height != 4 {
+		t.Fatalf("rolled back entries should be removed, got %v", entries)
+	}
+
+	// 高度按照数值排序,不受位数影响
+	dave := []byte{4}
+	for _, height := range []int64{9, 100, 10} {
+		AddHistory(1, []blockchain.HistoryEntry{{TxId: "c", Height: height, From: dave, To: dave}})
+	}
+	entries, next = GetHistory(1, "04", 99, 1)
+	if len(entries) != 1 || entries[0].Height != 10 || next != 9 {
+		t.Fatalf("unexpected page before height 99 %v, next %d", entries, next)
+	}
+	if entries, _ := GetHistory(1, "04", -1, 10); len(entries) != 3 || entries[0].Height != 100 || entries[2].Height != 9 {
+		t.Fatalf("entries should be ordered by height, got %v", entries)
+	}
+}
@@ -0,0 +1,25 @@
+package encapdb
+
+import (
+	"strconv"
+
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb/schema"
+)
+
+// 返回已经清理过状态的最高高度,-1表示从未清理过
+func GetPrunedHeight(chainId int64) int64 {
+	data, err := db.GetDBInst().Get(schema.PrunedHeightKey(chainId))
+	if err != nil {
+		return -1
+	}
+	height, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return height
+}
+
+func SetPrunedHeight(chainId, height int64) error {
+	return db.GetDBInst().Set(schema.PrunedHeightKey(chainId), []byte(strconv.FormatInt(height, 10)))
+}
@@ -0,0 +1,15 @@
+package schema
+
+import "fmt"
+
+func EvidenceKey(chainId int64, key string) []byte {
+	return []byte(fmt.Sprintf("Evidence_%d_%s", chainId, key))
+}
+
+func EvidenceListKey(chainId int64) []byte {
+	return []byte(fmt.Sprintf("EvidenceList_%d", chainId))
+}
+
+func EvidenceIncludedKey(chainId int64, key string) []byte {
+	return []byte(fmt.Sprintf("EvidenceIncluded_%d_%s", chainId, key))
+}
@@ -0,0 +1,7 @@
+package schema
+
+import "fmt"
+
+func FinalizedHeightKey(chainId int64) []byte {
+	return []byte(fmt.Sprintf("FinalizedHeight_%d", chainId))
+}
@@ -0,0 +1,7 @@
+package schema
+
+import "fmt"
+
+func PrunedHeightKey(chainId int64) []byte {
+	return []byte(fmt.Sprintf("PrunedHeight_%d", chainId))
+}
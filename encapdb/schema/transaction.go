@@ -0,0 +1,16 @@
+package schema
+
+import "fmt"
+
+func TransactionKey(chainId int64, txId string) []byte {
+	return []byte(fmt.Sprintf("Transaction_%d_%s", chainId, txId))
+}
+
+// 高度补齐到20位,同一个地址的记录按照key排序就是按照高度排序
+func HistoryKey(chainId int64, address string, height int64) []byte {
+	return []byte(fmt.Sprintf("History_%d_%s_%020d", chainId, address, height))
+}
+
+func HistoryPrefix(chainId int64, address string) []byte {
+	return []byte(fmt.Sprintf("History_%d_%s_", chainId, address))
+}
@@ -0,0 +1,31 @@
+package encapdb
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb/schema"
+)
+
+func GetTxLocation(chainId int64, txId string) *blockchain.TxLocation {
+	data, err := db.GetDBInst().Get(schema.TransactionKey(chainId, strings.ToLower(txId)))
+	if err != nil {
+		return nil
+	}
+	var location blockchain.TxLocation
+	if err = json.Unmarshal(data, &location); err != nil {
+		return nil
+	}
+	return &location
+}
+
+func SetTxLocation(chainId int64, location blockchain.TxLocation) error {
+	data, _ := json.Marshal(location)
+	return db.GetDBInst().Set(schema.TransactionKey(chainId, strings.ToLower(location.TxId)), data)
+}
+
+func DeleteTxLocation(chainId int64, txId string) {
+	db.GetDBInst().Delete(schema.TransactionKey(chainId, strings.ToLower(txId)))
+}
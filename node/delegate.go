@@ -45,10 +45,12 @@ func (delegate DelegateNode) RecoverFromDB() {
 	delegate.dbft.RecoverFromDB()
 }
 
-func (delegate DelegateNode) Heartbeat(heartbeat types.Heartbeat) {
-	if heartbeat.Validate() {
-		delegate.dbft.ReceiveHeartbeat(heartbeat)
-	}
+func (delegate DelegateNode) Heartbeat(heartbeat types.Heartbeat) bool {
+	return delegate.dbft.ReceiveHeartbeat(heartbeat)
+}
+
+func (delegate DelegateNode) Liveness() []types.Liveness {
+	return delegate.dbft.LivenessTable()
 }
 
 func (delegate DelegateNode) BlockFromPeer(block blockchain.Block) {
@@ -66,6 +68,10 @@ func (delegate DelegateNode) VoteResultFromPeer(votes blockchain.Votes) {
 	delegate.dbft.RecieveVoteResult(votes)
 }
 
+func (delegate DelegateNode) SkipVoteFromPeer(vote blockchain.PeerSkipVote) {
+	delegate.dbft.SkipFromPeer(vote)
+}
+
 func (delegate DelegateNode) GetVoteResults(chainId int64, hash string) blockchain.Votes {
 	return encapdb.GetVoteResults(chainId, hash)
 }
@@ -37,7 +37,12 @@ func (node FullNode) GetBlockChain() *blockchain.BlockChain {
 	return node.blockchain
 }
 
-func (node FullNode) Heartbeat(heartbeat types.Heartbeat) {
+func (node FullNode) Heartbeat(heartbeat types.Heartbeat) bool {
+	return false
+}
+
+func (node FullNode) Liveness() []types.Liveness {
+	return nil
 }
 
 func (node FullNode) recoverFromDB() {
@@ -56,6 +61,10 @@ func (node FullNode) VoteResultFromPeer(votes blockchain.Votes) {
 	return
 }
 
+func (node FullNode) SkipVoteFromPeer(vote blockchain.PeerSkipVote) {
+	return
+}
+
 func (node FullNode) GetVoteResults(chainId int64, hash string) blockchain.Votes {
 	return encapdb.GetVoteResults(chainId, hash)
 }
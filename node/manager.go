@@ -4,8 +4,10 @@ import (
 	"encoding/hex"
 	"github.com/OpenOCC/OCC/blockchain"
 	"github.com/OpenOCC/OCC/conf"
+	"github.com/OpenOCC/OCC/consensus"
 	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/encapdb"
 	"github.com/OpenOCC/OCC/param"
 	"strings"
 )
@@ -34,7 +36,11 @@ func Init(env string) {
 }
 
 func checkEnv() string {
-	for _, peer := range param.MainChainDelegateNode {
+	delegates := param.MainChainDelegateNode
+	if header := encapdb.GetLastHeader(1); header != nil {
+		delegates = consensus.DelegatesOf(*header)
+	}
+	for _, peer := range delegates {
 		if peer.Equal(conf.EKTConfig.Node) {
 			pub, err := crypto.PubKey(conf.EKTConfig.PrivateKey)
 			if err != nil {
@@ -76,6 +82,10 @@ func VoteResultFromPeer(votes blockchain.Votes) {
 	fullNode.VoteResultFromPeer(votes)
 }
 
+func SkipVoteFromPeer(vote blockchain.PeerSkipVote) {
+	fullNode.SkipVoteFromPeer(vote)
+}
+
 /*
 	for all node
 */
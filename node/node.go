@@ -16,5 +16,7 @@ type Node interface {
 	BlockFromPeer(block blockchain.Block)
 	VoteFromPeer(vote blockchain.PeerBlockVote)
 	VoteResultFromPeer(votes blockchain.Votes)
-	Heartbeat(heartbeat types.Heartbeat)
+	SkipVoteFromPeer(vote blockchain.PeerSkipVote)
+	Heartbeat(heartbeat types.Heartbeat) bool
+	Liveness() []types.Liveness
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/OpenOCC/OCC/core/types"
 	"github.com/OpenOCC/OCC/util"
 	"strconv"
+	"sync"
 	"xserver/x_http/x_resp"
 )
 
@@ -23,19 +24,47 @@ type IClient interface {
 	BroadcastBlock(block blockchain.Block)
 	SendVote(vote blockchain.PeerBlockVote)
 	SendVoteResult(votes blockchain.Votes)
-	SendHeartbeat()
+	SendSkipVote(vote blockchain.PeerSkipVote)
+	SendHeartbeat(heartbeat types.Heartbeat)
+
+	// 委托人集合变化之后更新需要通信的节点
+	SetPeers(peers []types.Peer)
 }
 
 type Client struct {
-	peers []types.Peer
+	peers  *[]types.Peer
+	locker *sync.RWMutex
 }
 
 func NewClient(peers []types.Peer) IClient {
-	return Client{peers: peers}
+	return Client{peers: &peers, locker: &sync.RWMutex{}}
+}
+
+func (client Client) SetPeers(peers []types.Peer) {
+	client.locker.Lock()
+	*client.peers = peers
+	client.locker.Unlock()
+}
+
+func (client Client) getPeers() []types.Peer {
+	client.locker.RLock()
+	defer client.locker.RUnlock()
+	return *client.peers
+}
+
+// 查询数据时跳过本节点,本节点的数据不能作为其他节点的结果
+func (client Client) remotePeers() []types.Peer {
+	peers := make([]types.Peer, 0)
+	for _, peer := range client.getPeers() {
+		if !peer.Equal(conf.EKTConfig.Node) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
 }
 
 func (client Client) GetHeaderByHeight(height int64) *blockchain.Header {
-	for _, peer := range client.peers {
+	for _, peer := range client.remotePeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/block/api/getHeaderByHeight?height=", strconv.Itoa(int(height)))
 		body, err := util.HttpGet(url)
 		if err != nil {
@@ -49,21 +78,23 @@ func (client Client) GetHeaderByHeight(height int64) *blockchain.Header {
 }
 
 func (client Client) GetBlockByHeight(height int64) *blockchain.Block {
-	for _, peer := range client.peers {
+	for _, peer := range client.remotePeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/block/api/getBlockByHeight?height=", strconv.Itoa(int(height)))
 		body, err := util.HttpGet(url)
 		if err != nil {
 			continue
 		}
-		if block := blockchain.GetBlockFromBytes(body); block != nil {
-			return block
+		if message := blockchain.GetBlockMessageFromBytes(body); message != nil {
+			if block := message.ToBlock(); block != nil {
+				return block
+			}
 		}
 	}
 	return nil
 }
 
 func (client Client) GetLastBlock(peer types.Peer) *blockchain.Header {
-	for _, peer := range client.peers {
+	for _, peer := range client.remotePeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/block/api/last")
 		body, err := util.HttpGet(url)
 		if err != nil {
@@ -77,7 +108,7 @@ func (client Client) GetLastBlock(peer types.Peer) *blockchain.Header {
 }
 
 func (client Client) GetVotesByBlockHash(hash string) blockchain.Votes {
-	for _, peer := range client.peers {
+	for _, peer := range client.remotePeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/vote/api/getVotes?hash=", hash)
 		body, err := util.HttpGet(url)
 		if err != nil {
@@ -100,8 +131,12 @@ func (client Client) GetVotesByBlockHash(hash string) blockchain.Votes {
 }
 
 func (client Client) BroadcastBlock(block blockchain.Block) {
-	data := block.Bytes()
-	for _, peer := range client.peers {
+	message := blockchain.NewBlockMessage(block)
+	if message == nil {
+		return
+	}
+	data := message.Bytes()
+	for _, peer := range client.getPeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/block/api/blockFromPeer")
 		go util.HttpPost(url, data)
 	}
@@ -109,7 +144,7 @@ func (client Client) BroadcastBlock(block blockchain.Block) {
 
 func (client Client) SendVote(vote blockchain.PeerBlockVote) {
 	data := vote.Bytes()
-	for _, peer := range client.peers {
+	for _, peer := range client.getPeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/vote/api/vote")
 		go util.HttpPost(url, data)
 	}
@@ -117,17 +152,23 @@ func (client Client) SendVote(vote blockchain.PeerBlockVote) {
 
 func (client Client) SendVoteResult(votes blockchain.Votes) {
 	data := votes.Bytes()
-	for _, peer := range client.peers {
+	for _, peer := range client.getPeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/vote/api/voteResult")
 		go util.HttpPost(url, data)
 	}
 }
 
-func (client Client) SendHeartbeat() {
-	heartbeat := types.NewHeartbeat(conf.EKTConfig.Node)
-	heartbeat.Sign(conf.EKTConfig.GetPrivateKey())
+func (client Client) SendSkipVote(vote blockchain.PeerSkipVote) {
+	data := vote.Bytes()
+	for _, peer := range client.getPeers() {
+		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/vote/api/skip")
+		go util.HttpPost(url, data)
+	}
+}
+
+func (client Client) SendHeartbeat(heartbeat types.Heartbeat) {
 	data, _ := json.Marshal(heartbeat)
-	for _, peer := range client.peers {
+	for _, peer := range client.remotePeers() {
 		url := util.StringJoint("http://", peer.Address, ":", strconv.Itoa(int(peer.Port)), "/peer/api/heartbeat")
 		go util.HttpPost(url, data)
 	}
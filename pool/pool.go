@@ -41,6 +41,10 @@ func (pool *TxPool) Notify(txs []userevent.Transaction) {
 	}
 }
 
+func (pool *TxPool) Get(txId string) *userevent.Transaction {
+	return pool.all.Get(txId)
+}
+
 func (pool *TxPool) GetUserTxs(address string) *UserTxs {
 	return pool.usersTxs.m[address]
 }
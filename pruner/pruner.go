@@ -0,0 +1,178 @@
+package pruner
+
+import (
+	"time"
+
+	"github.com/OpenOCC/OCC/MPTPlus"
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb"
+	"github.com/OpenOCC/OCC/log"
+)
+
+const (
+	GC_MODE_ARCHIVE = "archive"
+	GC_MODE_PRUNED  = "pruned"
+
+	// 每隔多少个区块清理一次
+	pruneBatch = 64
+	// 每次暂停Commit删除的节点数量
+	sweepBatch = 1024
+
+	// Keep的最小值,还没有不可逆的区块可以回滚,回滚之后需要从父区块的状态重新计算
+	MIN_KEEP = 2 * pruneBatch
+)
+
+/**
+*Pruner只保留最近Keep个高度的状态树,删除更早的状态节点
+*
+*先标记最近Keep个高度的root可以访问到的所有节点,然后遍历上次清理之后到目标高度之间的root,
+*删除没有被标记的节点。节点是按照hash存储的,被标记的节点的子树一定也被标记,所以可以直接跳过
+*只删除树的中间节点和叶子节点,值和区块头、区块体一样按照内容的hash保存,可能被其他数据共用,不删除
+ */
+type Pruner struct {
+	ChainId int64
+	Keep    int64
+	DB      db.IKVDatabase
+}
+
+func NewPruner(chainId, keep int64) *Pruner {
+	return &Pruner{
+		ChainId: chainId,
+		Keep:    keep,
+		DB:      db.GetDBInst(),
+	}
+}
+
+// 在后台定期清理,不阻塞共识
+func (pruner *Pruner) Run(chain *blockchain.BlockChain) {
+	go func() {
+		for {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.PrintStack("pruner.Run")
+					}
+				}()
+				height := chain.GetLastHeight()
+				// 只清理不可逆的高度,可以回滚的区块的父区块状态必须保留
+				target := height - pruner.Keep
+				if finalized := encapdb.GetFinalizedHeight(pruner.ChainId); target > finalized {
+					target = finalized
+				}
+				if target-encapdb.GetPrunedHeight(pruner.ChainId) >= pruneBatch {
+					if err := pruner.Prune(target, height); err != nil {
+						log.Error("Prune state to height %d failed, %v", target, err)
+					}
+				}
+			}()
+			time.Sleep(blockchain.BackboneBlockInterval * pruneBatch / 4)
+		}
+	}()
+}
+
+// 删除高度小于等于target的状态节点,保留target之后的状态
+// 标记期间不暂停Commit,height之后新写入链中的区块和Commit的root在删除每一批节点之前标记
+func (pruner *Pruner) Prune(target, height int64) error {
+	watcher := MPTPlus.WatchCommits(pruner.DB)
+	defer watcher.Stop()
+
+	start := time.Now()
+	keep := make(map[string]bool)
+	mark := func(tree *MPTPlus.MTP) error {
+		return tree.WalkNodes(func(hash []byte, isValue bool) bool {
+			if isValue || keep[string(hash)] {
+				return false
+			}
+			keep[string(hash)] = true
+			return true
+		})
+	}
+	for h := target + 1; ; h++ {
+		header := encapdb.GetHeaderByHeight(pruner.ChainId, h)
+		if header == nil {
+			if h > height {
+				break
+			}
+			continue
+		}
+		for _, tree := range pruner.trees(header) {
+			if err := mark(tree); err != nil {
+				return err
+			}
+		}
+	}
+	markRoots := func(roots [][]byte) {
+		for _, root := range roots {
+			if err := mark(MPTPlus.MTP_Tree(pruner.DB, root)); err != nil {
+				log.Warn("Walk recently committed root %x failed, %v", root, err)
+			}
+		}
+	}
+	markRoots(MPTPlus.RecentRoots(pruner.DB))
+
+	stale := make(map[string]bool)
+	for h := encapdb.GetPrunedHeight(pruner.ChainId) + 1; h <= target; h++ {
+		header := encapdb.GetHeaderByHeight(pruner.ChainId, h)
+		if header == nil {
+			continue
+		}
+		for _, tree := range pruner.trees(header) {
+			err := tree.WalkNodes(func(hash []byte, isValue bool) bool {
+				if isValue || keep[string(hash)] || stale[string(hash)] {
+					return false
+				}
+				stale[string(hash)] = true
+				return true
+			})
+			if err != nil {
+				// 节点已经被删除的root不影响其他root的清理
+				log.Warn("Walk state of height %d failed, %v", h, err)
+			}
+		}
+	}
+
+	hashes := make([][]byte, 0, len(stale))
+	for hash := range stale {
+		hashes = append(hashes, []byte(hash))
+	}
+	deleted := 0
+	for len(hashes) > 0 {
+		n := sweepBatch
+		if n > len(hashes) {
+			n = len(hashes)
+		}
+		count, err := pruner.sweep(hashes[:n], keep, watcher, markRoots)
+		if err != nil {
+			return err
+		}
+		deleted += count
+		hashes = hashes[n:]
+	}
+	log.Info("Pruned %d state nodes to height %d, kept %d nodes, cost %v.", deleted, target, len(keep), time.Since(start))
+	return encapdb.SetPrunedHeight(pruner.ChainId, target)
+}
+
+// 暂停Commit删除一批节点,先标记标记阶段之后Commit的root,这些root可能重新引用了过期的节点
+func (pruner *Pruner) sweep(hashes [][]byte, keep map[string]bool, watcher *MPTPlus.CommitWatcher, markRoots func([][]byte)) (int, error) {
+	resume := MPTPlus.PauseCommit()
+	defer resume()
+	markRoots(watcher.Take())
+	stale := make([][]byte, 0, len(hashes))
+	for _, hash := range hashes {
+		if !keep[string(hash)] {
+			stale = append(stale, hash)
+		}
+	}
+	return len(stale), MPTPlus.DeleteNodes(pruner.DB, stale)
+}
+
+func (pruner *Pruner) trees(header *blockchain.Header) []*MPTPlus.MTP {
+	trees := make([]*MPTPlus.MTP, 0, 4)
+	for _, tree := range []*MPTPlus.MTP{header.StatTree, header.TokenTree, header.DelegateTree, header.VoteTree} {
+		if tree != nil {
+			trees = append(trees, MPTPlus.MTP_Tree(pruner.DB, tree.Root))
+		}
+	}
+	return trees
+}
@@ -0,0 +1,127 @@
+package pruner
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/OpenOCC/OCC/MPTPlus"
+	"github.com/OpenOCC/OCC/blockchain"
+	"github.com/OpenOCC/OCC/crypto"
+	"github.com/OpenOCC/OCC/db"
+	"github.com/OpenOCC/OCC/encapdb"
+	"github.com/OpenOCC/OCC/log"
+)
+
+func TestPrune(t *testing.T) {
+	log.InitLog("/tmp/pruner_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	stat, token := MPTPlus.NewMTP(db.GetDBInst()), MPTPlus.NewMTP(db.GetDBInst())
+	roots := make([][]byte, 0)
+	for h := int64(0); h < 10; h++ {
+		stat.MustInsert([]byte(fmt.Sprintf("account%d", h%3)), []byte(fmt.Sprintf("value at %d", h)))
+		header := blockchain.Header{
+			Height:    h,
+			StatTree:  MPTPlus.MTP_Tree(db.GetDBInst(), stat.Root),
+			TokenTree: MPTPlus.MTP_Tree(db.GetDBInst(), token.Root),
+		}
+		encapdb.SetHeaderByHeight(1, h, header)
+		roots = append(roots, stat.Root)
+	}
+
+	pruner := NewPruner(1, 4)
+	if err := pruner.Prune(5, 9); err != nil {
+		t.Fatal(err)
+	}
+	if encapdb.GetPrunedHeight(1) != 5 {
+		t.Fatalf("pruned height is %d", encapdb.GetPrunedHeight(1))
+	}
+	for h := 6; h < 10; h++ {
+		trie := MPTPlus.MTP_Tree(db.GetDBInst(), roots[h])
+		for i := h - 2; i <= h; i++ {
+			if v, err := trie.GetValue([]byte(fmt.Sprintf("account%d", i%3))); err != nil || string(v) != fmt.Sprintf("value at %d", i) {
+				t.Fatalf("state of height %d lost, value=%s, err=%v", h, v, err)
+			}
+		}
+	}
+	for h := 0; h <= 5; h++ {
+		if _, err := db.GetDBInst().Get(roots[h]); err == nil {
+			t.Fatalf("root of height %d not pruned", h)
+		}
+	}
+	// 值按照内容的hash保存,可能和区块头、区块体共用同一个key,不能删除
+	if _, err := db.GetDBInst().Get(crypto.Sha3_256([]byte("value at 0"))); err != nil {
+		t.Fatal("values should not be swept")
+	}
+}
+
+// 清理开始之后Commit的root在删除之前标记
+func TestCommitWatcher(t *testing.T) {
+	log.InitLog("/tmp/pruner_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	watcher := MPTPlus.WatchCommits(db.GetDBInst())
+	trie := MPTPlus.NewMTP(db.GetDBInst())
+	trie.Stage()
+	trie.MustInsert([]byte("account"), []byte("value"))
+	if err := trie.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if roots := watcher.Take(); len(roots) != 1 || !bytes.Equal(roots[0], trie.Root) {
+		t.Fatalf("committed root should be watched, got %x", roots)
+	}
+	watcher.Stop()
+	trie.MustInsert([]byte("account"), []byte("value2"))
+	if err := trie.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if roots := watcher.Take(); len(roots) != 0 {
+		t.Fatal("stopped watcher should not record roots")
+	}
+}
+
+// 还没有写入链中的区块Commit的节点和过期节点相同时不能被删除,删除的节点也不能继续从缓存中读取
+func TestPruneKeepsRecentCommits(t *testing.T) {
+	log.InitLog("/tmp/pruner_test.log")
+	db.EktDB = db.NewMemKVDatabase()
+	stat, token := MPTPlus.NewMTP(db.GetDBInst()), MPTPlus.NewMTP(db.GetDBInst())
+	roots := make([][]byte, 0)
+	for h := int64(0); h < 10; h++ {
+		stat.MustInsert([]byte(fmt.Sprintf("account%d", h%3)), []byte(fmt.Sprintf("value at %d", h)))
+		header := blockchain.Header{
+			Height:    h,
+			StatTree:  MPTPlus.MTP_Tree(db.GetDBInst(), stat.Root),
+			TokenTree: MPTPlus.MTP_Tree(db.GetDBInst(), token.Root),
+		}
+		encapdb.SetHeaderByHeight(1, h, header)
+		roots = append(roots, stat.Root)
+	}
+	if _, err := MPTPlus.MTP_Tree(db.GetDBInst(), roots[0]).GetValue([]byte("account0")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 账户恢复到高度2时的状态,新的root和已经过期的roots[2]相同
+	pending := MPTPlus.MTP_Tree(db.GetDBInst(), roots[9])
+	pending.Stage()
+	for h := 0; h < 3; h++ {
+		pending.MustInsert([]byte(fmt.Sprintf("account%d", h)), []byte(fmt.Sprintf("value at %d", h)))
+	}
+	if err := pending.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pending.Root, roots[2]) {
+		t.Fatal("pending root should be the same as the root of height 2")
+	}
+
+	if err := NewPruner(1, 4).Prune(5, 9); err != nil {
+		t.Fatal(err)
+	}
+	trie := MPTPlus.MTP_Tree(db.GetDBInst(), pending.Root)
+	for h := 0; h < 3; h++ {
+		if v, err := trie.GetValue([]byte(fmt.Sprintf("account%d", h))); err != nil || string(v) != fmt.Sprintf("value at %d", h) {
+			t.Fatalf("recently committed state lost, value=%s, err=%v", v, err)
+		}
+	}
+	if _, err := MPTPlus.MTP_Tree(db.GetDBInst(), roots[0]).GetValue([]byte("account0")); err == nil {
+		t.Fatal("pruned node should not be served from the cache")
+	}
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// 共识和打包使用的时钟,测试中可以替换成FakeClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+/*
+*FakeClock只在调用Set、Advance和Sleep时前进
+*模拟环境中所有的调用都在同一个线程中执行,After不能阻塞,返回的channel中已经写入到期的时间,但是不推进时钟
+ */
+type FakeClock struct {
+	now    time.Time
+	locker *sync.RWMutex
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start, locker: &sync.RWMutex{}}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.locker.RLock()
+	defer clock.locker.RUnlock()
+	return clock.now
+}
+
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- clock.Now().Add(d)
+	return ch
+}
+
+func (clock *FakeClock) Sleep(d time.Duration) {
+	clock.Advance(d)
+}
+
+func (clock *FakeClock) Advance(d time.Duration) time.Time {
+	clock.locker.Lock()
+	defer clock.locker.Unlock()
+	if d > 0 {
+		clock.now = clock.now.Add(d)
+	}
+	return clock.now
+}
+
+// 时间只能向后设置
+func (clock *FakeClock) Set(t time.Time) {
+	clock.locker.Lock()
+	defer clock.locker.Unlock()
+	if t.After(clock.now) {
+		clock.now = t
+	}
+}
+
+// 毫秒时间戳,和区块头中的Timestamp单位一致
+func UnixMilli(clock Clock) int64 {
+	return clock.Now().UnixNano() / 1e6
+}
@@ -26,3 +26,8 @@ func MoreThanHalf(n int) int {
 	half := n/2 + 1
 	return half
 }
+
+// 超过2/3的最小数量,拜占庭容错需要的法定人数
+func MoreThanTwoThirds(n int) int {
+	return n*2/3 + 1
+}